@@ -0,0 +1,214 @@
+// library-catalog импортирует и выгружает каталог library-system через административный API:
+//
+//	library-catalog import [-dry-run] [-format csv|json] <file>
+//	library-catalog export [-format csv|json] [-o <file>]
+//
+// Адрес сервиса задается флагом -url или переменной LIBRARY_SYSTEM_URL, токен - флагом -token или ADMIN_TOKEN.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultURL     = "http://localhost:8060/api/v1"
+	requestTimeout = 2 * time.Minute
+)
+
+type importResult struct {
+	Row        int    `json:"row"`
+	BookUid    string `json:"bookUid"`
+	LibraryUid string `json:"libraryUid"`
+	Status     string `json:"status"`
+	Error      string `json:"error"`
+}
+
+type importResponse struct {
+	DryRun    bool           `json:"dryRun"`
+	Applied   bool           `json:"applied"`
+	Total     int            `json:"total"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Rows      []importResult `json:"rows"`
+	Message   string         `json:"message"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: library-catalog import [-dry-run] [-format csv|json] <file>")
+	fmt.Fprintln(os.Stderr, "       library-catalog export [-format csv|json] [-o <file>]")
+}
+
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func commonFlags(fs *flag.FlagSet) (*string, *string) {
+	baseURL := fs.String("url", envOr("LIBRARY_SYSTEM_URL", defaultURL), "library-system api url")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin token")
+	return baseURL, token
+}
+
+func newClient(baseURL, token string) *client {
+	return &client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+func (c *client) do(method, path string, query url.Values, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.http.Do(req)
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	baseURL, token := commonFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "validate the catalog and roll back all changes")
+	format := fs.String("format", "", "catalog format: csv or json, by default from the file extension")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("catalog file is required")
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = "json"
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			*format = "csv"
+		}
+	}
+
+	contentType := "application/json"
+	if *format == "csv" {
+		contentType = "text/csv"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	query := url.Values{}
+	query.Set("format", *format)
+	query.Set("dryRun", fmt.Sprint(*dryRun))
+
+	resp, err := newClient(*baseURL, *token).do(http.MethodPost, "/admin/catalog/import", query, contentType, file)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	res := importResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return fmt.Errorf("unexpected response with status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		return fmt.Errorf("import failed with status %d: %s", resp.StatusCode, res.Message)
+	}
+
+	for _, row := range res.Rows {
+		line := fmt.Sprintf("row %d: %s", row.Row, row.Status)
+		if row.BookUid != "" {
+			line += " book " + row.BookUid
+		}
+		if row.LibraryUid != "" {
+			line += " library " + row.LibraryUid
+		}
+		if row.Error != "" {
+			line += ": " + row.Error
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("total %d, succeeded %d, failed %d, dry run %t, applied %t\n", res.Total, res.Succeeded, res.Failed, res.DryRun, res.Applied)
+
+	if res.Failed > 0 {
+		return errors.New("catalog is not imported")
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	baseURL, token := commonFlags(fs)
+	format := fs.String("format", "csv", "catalog format: csv or json")
+	output := fs.String("o", "", "output file, stdout by default")
+	_ = fs.Parse(args)
+
+	query := url.Values{}
+	query.Set("format", *format)
+
+	resp, err := newClient(*baseURL, *token).do(http.MethodGet, "/admin/catalog/export", query, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("export failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,123 @@
+package circuit_breakers
+
+import (
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/Erlendum/rsoi-lab-03/pkg/auth"
+	circuit_breaker "github.com/Erlendum/rsoi-lab-03/pkg/circuit-breaker"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type circuitBreaker interface {
+	Name() string
+	Snapshot() circuit_breaker.Snapshot
+	ForceOpen()
+	ForceClose()
+	Reset()
+}
+
+type handler struct {
+	circuitBreakers map[string]circuitBreaker
+	cfg             config.Admin
+}
+
+func NewHandler(cfg config.Admin) *handler {
+	return &handler{circuitBreakers: map[string]circuitBreaker{}, cfg: cfg}
+}
+
+// Add делает breaker доступным через /manage/circuit-breakers под его именем.
+func (h *handler) Add(cb circuitBreaker) {
+	h.circuitBreakers[cb.Name()] = cb
+}
+
+func (h *handler) Register(echo *echo.Echo) {
+	// принудительное открытие и закрытие breaker'ов меняет обработку запросов, поэтому доступно только с токеном
+	api := echo.Group("/manage/circuit-breakers", auth.AdminToken(h.cfg.Token))
+
+	api.GET("", h.GetCircuitBreakers)
+	api.GET("/:name", h.GetCircuitBreaker)
+	api.POST("/:name/open", h.OpenCircuitBreaker)
+	api.POST("/:name/close", h.CloseCircuitBreaker)
+	api.POST("/:name/reset", h.ResetCircuitBreaker)
+}
+
+type circuitBreakerResp struct {
+	Name            string     `json:"name"`
+	State           string     `json:"state"`
+	Forced          bool       `json:"forced"`
+	Requests        uint64     `json:"requests"`
+	Failures        uint64     `json:"failures"`
+	TotalSuccesses  uint64     `json:"totalSuccesses"`
+	TotalFailures   uint64     `json:"totalFailures"`
+	TotalRejections uint64     `json:"totalRejections"`
+	OpenTimeout     string     `json:"openTimeout,omitempty"`
+	NextProbeAt     *time.Time `json:"nextProbeAt"`
+}
+
+func newCircuitBreakerResp(s circuit_breaker.Snapshot) circuitBreakerResp {
+	res := circuitBreakerResp{
+		Name:            s.Name,
+		State:           string(s.State),
+		Forced:          s.Forced,
+		Requests:        s.Requests,
+		Failures:        s.Failures,
+		TotalSuccesses:  s.TotalSuccesses,
+		TotalFailures:   s.TotalFailures,
+		TotalRejections: s.TotalRejections,
+	}
+	if s.OpenTimeout != 0 {
+		res.OpenTimeout = s.OpenTimeout.String()
+	}
+	if !s.NextProbeAt.IsZero() {
+		res.NextProbeAt = &s.NextProbeAt
+	}
+	return res
+}
+
+func (h *handler) GetCircuitBreakers(c echo.Context) error {
+	items := make([]circuitBreakerResp, 0, len(h.circuitBreakers))
+	for _, cb := range h.circuitBreakers {
+		items = append(items, newCircuitBreakerResp(cb.Snapshot()))
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+
+	return c.JSON(http.StatusOK, items)
+}
+
+func (h *handler) GetCircuitBreaker(c echo.Context) error {
+	cb, ok := h.circuitBreakers[c.Param("name")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "circuit breaker not found"})
+	}
+
+	return c.JSON(http.StatusOK, newCircuitBreakerResp(cb.Snapshot()))
+}
+
+func (h *handler) OpenCircuitBreaker(c echo.Context) error {
+	return h.apply(c, "open", circuitBreaker.ForceOpen)
+}
+
+func (h *handler) CloseCircuitBreaker(c echo.Context) error {
+	return h.apply(c, "close", circuitBreaker.ForceClose)
+}
+
+func (h *handler) ResetCircuitBreaker(c echo.Context) error {
+	return h.apply(c, "reset", circuitBreaker.Reset)
+}
+
+func (h *handler) apply(c echo.Context, action string, f func(cb circuitBreaker)) error {
+	cb, ok := h.circuitBreakers[c.Param("name")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "circuit breaker not found"})
+	}
+
+	f(cb)
+	log.Warn().Str("circuitBreaker", cb.Name()).Str("action", action).Msg("circuit breaker state changed manually")
+
+	return c.JSON(http.StatusOK, newCircuitBreakerResp(cb.Snapshot()))
+}
@@ -0,0 +1,134 @@
+package circuit_breakers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	circuit_breaker "github.com/Erlendum/rsoi-lab-03/pkg/circuit-breaker"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_GetCircuitBreakers(t *testing.T) {
+	h := NewHandler(config.Admin{})
+	h.Add(circuit_breaker.New(1, time.Minute, circuit_breaker.WithName("getRatingByUser")))
+	opened := circuit_breaker.New(1, time.Minute, circuit_breaker.WithName("getLibraries"))
+	_ = opened.Call(func() error { return errors.New("") })
+	h.Add(opened)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/manage/circuit-breakers", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	err := h.GetCircuitBreakers(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var items []circuitBreakerResp
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &items))
+	require.Len(t, items, 2)
+	require.Equal(t, "getLibraries", items[0].Name)
+	require.Equal(t, "open", items[0].State)
+	require.Equal(t, uint64(1), items[0].TotalFailures)
+	require.NotNil(t, items[0].NextProbeAt)
+	require.Equal(t, "getRatingByUser", items[1].Name)
+	require.Equal(t, "closed", items[1].State)
+	require.Nil(t, items[1].NextProbeAt)
+}
+
+func Test_ForceCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name             string
+		breakerName      string
+		call             func(h *handler, c echo.Context) error
+		expectedHTTPCode int
+		expectedState    string
+		expectedForced   bool
+	}{
+		{
+			name:             "http-code 404: unknown breaker",
+			breakerName:      "unknown",
+			call:             (*handler).OpenCircuitBreaker,
+			expectedHTTPCode: http.StatusNotFound,
+		},
+		{
+			name:             "http-code 200: force open",
+			breakerName:      "getRatingByUser",
+			call:             (*handler).OpenCircuitBreaker,
+			expectedHTTPCode: http.StatusOK,
+			expectedState:    "open",
+			expectedForced:   true,
+		},
+		{
+			name:             "http-code 200: force close",
+			breakerName:      "getRatingByUser",
+			call:             (*handler).CloseCircuitBreaker,
+			expectedHTTPCode: http.StatusOK,
+			expectedState:    "closed",
+			expectedForced:   true,
+		},
+		{
+			name:             "http-code 200: reset",
+			breakerName:      "getRatingByUser",
+			call:             (*handler).ResetCircuitBreaker,
+			expectedHTTPCode: http.StatusOK,
+			expectedState:    "closed",
+			expectedForced:   false,
+		},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(config.Admin{})
+			h.Add(circuit_breaker.New(1, time.Minute, circuit_breaker.WithName("getRatingByUser")))
+
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("name")
+			c.SetParamValues(tt.breakerName)
+
+			err := tt.call(h, c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedHTTPCode, rec.Code)
+			if tt.expectedHTTPCode == http.StatusOK {
+				var item circuitBreakerResp
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &item))
+				require.Equal(t, tt.expectedState, item.State)
+				require.Equal(t, tt.expectedForced, item.Forced)
+			}
+		})
+	}
+}
+
+func Test_ManageCircuitBreakersRequiresAdminToken(t *testing.T) {
+	cb := circuit_breaker.New(1, time.Minute, circuit_breaker.WithName("getRatingByUser"))
+	h := NewHandler(config.Admin{Token: "secret"})
+	h.Add(cb)
+
+	e := echo.New()
+	h.Register(e)
+
+	req := httptest.NewRequest(http.MethodPost, "/manage/circuit-breakers/getRatingByUser/open", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.False(t, cb.Snapshot().Forced)
+
+	req = httptest.NewRequest(http.MethodPost, "/manage/circuit-breakers/getRatingByUser/open", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, cb.Snapshot().Forced)
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
@@ -12,9 +13,225 @@ type Server struct {
 	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 }
 
+const (
+	CircuitBreakerModeConsecutive = "consecutive"
+	CircuitBreakerModeFailureRate = "failure_rate"
+
+	CircuitBreakerWindowCount = "count"
+	CircuitBreakerWindowTime  = "time"
+
+	// отдельный breaker на каждую операцию или общий breaker на каждый сервис
+	CircuitBreakerScopeOperation = "operation"
+	CircuitBreakerScopeUpstream  = "upstream"
+)
+
 type CircuitBreaker struct {
-	MaxFailures  uint64        `yaml:"max_failures"`
-	ResetTimeout time.Duration `yaml:"reset_timeout"`
+	MaxFailures          uint64                    `yaml:"max_failures"`
+	ResetTimeout         time.Duration             `yaml:"reset_timeout"`
+	HalfOpenMaxCalls     uint64                    `yaml:"half_open_max_calls"`
+	Mode                 string                    `yaml:"mode"`
+	WindowType           string                    `yaml:"window_type"`
+	WindowSize           uint64                    `yaml:"window_size"`
+	WindowDuration       time.Duration             `yaml:"window_duration"`
+	FailureRateThreshold float64                   `yaml:"failure_rate_threshold"`
+	MinimumRequests      uint64                    `yaml:"minimum_requests"`
+	BackoffMultiplier    float64                   `yaml:"backoff_multiplier"`
+	MaxResetTimeout      time.Duration             `yaml:"max_reset_timeout"`
+	ResetJitter          float64                   `yaml:"reset_jitter"`
+	Scope                string                    `yaml:"scope"`
+	Breakers             map[string]CircuitBreaker `yaml:"breakers"`
+}
+
+// For возвращает настройки breaker'а с именем name: общие настройки, переопределенные заданными в breakers.
+func (c CircuitBreaker) For(name string) CircuitBreaker {
+	res := c
+	res.Breakers = nil
+
+	override, ok := c.Breakers[name]
+	if !ok {
+		return res
+	}
+	if override.MaxFailures != 0 {
+		res.MaxFailures = override.MaxFailures
+	}
+	if override.ResetTimeout != 0 {
+		res.ResetTimeout = override.ResetTimeout
+	}
+	if override.HalfOpenMaxCalls != 0 {
+		res.HalfOpenMaxCalls = override.HalfOpenMaxCalls
+	}
+	if override.Mode != "" {
+		res.Mode = override.Mode
+	}
+	if override.WindowType != "" {
+		res.WindowType = override.WindowType
+	}
+	if override.WindowSize != 0 {
+		res.WindowSize = override.WindowSize
+	}
+	if override.WindowDuration != 0 {
+		res.WindowDuration = override.WindowDuration
+	}
+	if override.FailureRateThreshold != 0 {
+		res.FailureRateThreshold = override.FailureRateThreshold
+	}
+	if override.MinimumRequests != 0 {
+		res.MinimumRequests = override.MinimumRequests
+	}
+	if override.BackoffMultiplier != 0 {
+		res.BackoffMultiplier = override.BackoffMultiplier
+	}
+	if override.MaxResetTimeout != 0 {
+		res.MaxResetTimeout = override.MaxResetTimeout
+	}
+	if override.ResetJitter != 0 {
+		res.ResetJitter = override.ResetJitter
+	}
+	return res
+}
+
+func (c CircuitBreaker) validate() error {
+	if c.BackoffMultiplier != 0 && c.BackoffMultiplier < 1 {
+		return fmt.Errorf("backoff_multiplier must be at least 1, got %v", c.BackoffMultiplier)
+	}
+	if c.ResetJitter < 0 || c.ResetJitter >= 1 {
+		return fmt.Errorf("reset_jitter must be in [0, 1), got %v", c.ResetJitter)
+	}
+	if c.MaxResetTimeout != 0 && c.MaxResetTimeout < c.ResetTimeout {
+		return errors.New("max_reset_timeout must not be less than reset_timeout")
+	}
+
+	switch c.Mode {
+	case "", CircuitBreakerModeConsecutive:
+	case CircuitBreakerModeFailureRate:
+		if c.FailureRateThreshold <= 0 || c.FailureRateThreshold > 1 {
+			return fmt.Errorf("failure_rate_threshold must be in (0, 1], got %v", c.FailureRateThreshold)
+		}
+		switch c.WindowType {
+		case CircuitBreakerWindowCount:
+			if c.WindowSize == 0 {
+				return errors.New("window_size must be positive for count window")
+			}
+		case CircuitBreakerWindowTime:
+			if c.WindowDuration <= 0 {
+				return errors.New("window_duration must be positive for time window")
+			}
+		default:
+			return fmt.Errorf("unknown window_type %q", c.WindowType)
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	return nil
+}
+
+func (c CircuitBreaker) Validate() error {
+	switch c.Scope {
+	case "", CircuitBreakerScopeOperation, CircuitBreakerScopeUpstream:
+	default:
+		return fmt.Errorf("circuit_breaker: unknown scope %q", c.Scope)
+	}
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("circuit_breaker: %w", err)
+	}
+	for name := range c.Breakers {
+		if err := c.For(name).validate(); err != nil {
+			return fmt.Errorf("circuit_breaker.breakers.%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// RetryQueue - очередь отложенных операций gateway. Задержка перед повтором растет от initial_delay
+// в multiplier раз с каждой попыткой до max_delay, команда уходит в dead-letter после max_attempts
+// попыток или по истечении ttl с момента приема (нулевые значения снимают ограничение).
+// Команды, ожидающие разных сервисов, выполняются независимо: не больше workers одновременно
+// на каждый сервис, upstream_workers переопределяет это число для отдельных сервисов.
+type RetryQueue struct {
+	Path            string         `yaml:"path"`
+	InitialDelay    time.Duration  `yaml:"initial_delay"`
+	Multiplier      float64        `yaml:"multiplier"`
+	MaxDelay        time.Duration  `yaml:"max_delay"`
+	Jitter          float64        `yaml:"jitter"`
+	MaxAttempts     int            `yaml:"max_attempts"`
+	TTL             time.Duration  `yaml:"ttl"`
+	Workers         int            `yaml:"workers"`
+	UpstreamWorkers map[string]int `yaml:"upstream_workers"`
+}
+
+func (c RetryQueue) Validate() error {
+	if c.Path == "" {
+		return errors.New("retry_queue: path is required")
+	}
+	if c.InitialDelay <= 0 {
+		return errors.New("retry_queue: initial_delay must be positive")
+	}
+	if c.Multiplier != 0 && c.Multiplier < 1 {
+		return fmt.Errorf("retry_queue: multiplier must be at least 1, got %v", c.Multiplier)
+	}
+	if c.MaxDelay != 0 && c.MaxDelay < c.InitialDelay {
+		return errors.New("retry_queue: max_delay must not be less than initial_delay")
+	}
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		return fmt.Errorf("retry_queue: jitter must be in [0, 1), got %v", c.Jitter)
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("retry_queue: max_attempts must not be negative, got %d", c.MaxAttempts)
+	}
+	if c.TTL < 0 {
+		return errors.New("retry_queue: ttl must not be negative")
+	}
+	if c.Workers < 0 {
+		return fmt.Errorf("retry_queue: workers must not be negative, got %d", c.Workers)
+	}
+	for upstream, workers := range c.UpstreamWorkers {
+		if workers <= 0 {
+			return fmt.Errorf("retry_queue.upstream_workers.%s: must be positive, got %d", upstream, workers)
+		}
+	}
+	return nil
+}
+
+// Saga - журнал саг gateway. Откаты, которые не удалось завершить, повторяются каждые recovery_interval.
+type Saga struct {
+	Path             string        `yaml:"path"`
+	RecoveryInterval time.Duration `yaml:"recovery_interval"`
+}
+
+func (c Saga) Validate() error {
+	if c.Path == "" {
+		return errors.New("saga: path is required")
+	}
+	if c.RecoveryInterval <= 0 {
+		return errors.New("saga: recovery_interval must be positive")
+	}
+	return nil
+}
+
+// Idempotency - ключи идемпотентности запросов gateway. Ответ на ключ хранится ttl,
+// устаревшие ключи удаляются каждые cleanup_interval.
+type Idempotency struct {
+	Path            string        `yaml:"path"`
+	TTL             time.Duration `yaml:"ttl"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+func (c Idempotency) Validate() error {
+	if c.Path == "" {
+		return errors.New("idempotency: path is required")
+	}
+	if c.TTL <= 0 {
+		return errors.New("idempotency: ttl must be positive")
+	}
+	if c.CleanupInterval <= 0 {
+		return errors.New("idempotency: cleanup_interval must be positive")
+	}
+	return nil
+}
+
+// Admin - доступ к административному API, токен задается переменной окружения ADMIN_TOKEN
+type Admin struct {
+	Token string `env:"ADMIN_TOKEN"`
 }
 
 type Config struct {
@@ -23,6 +240,10 @@ type Config struct {
 	LibrarySystemURL     string         `yaml:"library_system_url"`
 	RatingSystemURL      string         `yaml:"rating_system_url"`
 	CircuitBreaker       CircuitBreaker `yaml:"circuit_breaker"`
+	RetryQueue           RetryQueue     `yaml:"retry_queue"`
+	Saga                 Saga           `yaml:"saga"`
+	Idempotency          Idempotency    `yaml:"idempotency"`
+	Admin                Admin
 }
 
 func New() (*Config, error) {
@@ -36,5 +257,22 @@ func New() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	cfg.Admin.Token = os.Getenv("ADMIN_TOKEN")
+	err = cfg.CircuitBreaker.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.RetryQueue.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Saga.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Idempotency.Validate()
+	if err != nil {
+		return nil, err
+	}
 	return cfg, err
 }
@@ -15,23 +15,54 @@ type librarySystemHandler interface {
 	Register(echo *echo.Echo)
 	GetLibraries(c echo.Context) error
 	GetBooksByLibrary(c echo.Context) error
+	SearchBooks(c echo.Context) error
 	GetBooksByUser(c echo.Context) error
 	ReserveBookByUser(c echo.Context) error
 	ReturnBookByUser(c echo.Context) error
 	GetRatingByUser(c echo.Context) error
+	GetRatingHistory(c echo.Context) error
+	AdminProxy(c echo.Context) error
+}
+
+type metricsHandler interface {
+	Register(echo *echo.Echo)
+	GetMetrics(c echo.Context) error
+}
+
+type circuitBreakersHandler interface {
+	Register(echo *echo.Echo)
+	GetCircuitBreakers(c echo.Context) error
+	GetCircuitBreaker(c echo.Context) error
+	OpenCircuitBreaker(c echo.Context) error
+	CloseCircuitBreaker(c echo.Context) error
+	ResetCircuitBreaker(c echo.Context) error
+}
+
+type retryHandler interface {
+	Register(echo *echo.Echo)
+	GetRetries(c echo.Context) error
+	GetDeadRetries(c echo.Context) error
+	ReplayRetry(c echo.Context) error
+	DiscardRetry(c echo.Context) error
 }
 
 type server struct {
-	echo                 *echo.Echo
-	cfg                  *config.Server
-	librarySystemHandler librarySystemHandler
+	echo                   *echo.Echo
+	cfg                    *config.Server
+	librarySystemHandler   librarySystemHandler
+	metricsHandler         metricsHandler
+	circuitBreakersHandler circuitBreakersHandler
+	retryHandler           retryHandler
 }
 
-func NewServer(cfg *config.Server, librarySystemHandler librarySystemHandler) *server {
+func NewServer(cfg *config.Server, librarySystemHandler librarySystemHandler, metricsHandler metricsHandler, circuitBreakersHandler circuitBreakersHandler, retryHandler retryHandler) *server {
 	return &server{
-		echo:                 echo.New(),
-		librarySystemHandler: librarySystemHandler,
-		cfg:                  cfg,
+		echo:                   echo.New(),
+		librarySystemHandler:   librarySystemHandler,
+		metricsHandler:         metricsHandler,
+		circuitBreakersHandler: circuitBreakersHandler,
+		retryHandler:           retryHandler,
+		cfg:                    cfg,
 	}
 }
 
@@ -51,6 +82,9 @@ func (s *server) Init() error {
 	s.echo.Validator = validation.MustRegisterCustomValidator(validator.New())
 
 	s.librarySystemHandler.Register(s.echo)
+	s.metricsHandler.Register(s.echo)
+	s.circuitBreakersHandler.Register(s.echo)
+	s.retryHandler.Register(s.echo)
 
 	s.echo.GET("/manage/health", func(c echo.Context) error {
 		return c.NoContent(http.StatusOK)
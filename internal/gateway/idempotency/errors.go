@@ -0,0 +1,5 @@
+package idempotency
+
+import "errors"
+
+var errRecordNotFound = errors.New("idempotency record not found")
@@ -0,0 +1,216 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	HeaderReplayed       = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+)
+
+type storage interface {
+	Get(key string) (Record, error)
+	Put(key string, record Record) error
+	DeleteCreatedBefore(before time.Time) (int, error)
+}
+
+// keyLock сериализует запросы с одним ключом, refs - число запросов, ожидающих или держащих блокировку
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// middleware выполняет запрос с заголовком Idempotency-Key один раз для пары пользователь + ключ:
+// первый ответ сохраняется на ttl и возвращается на повторы, одновременные повторы ждут первый запрос.
+// Ответы 5xx не сохраняются, чтобы запрос можно было повторить после сбоя.
+type middleware struct {
+	storage storage
+	cfg     config.Idempotency
+	mu      sync.Mutex
+	locks   map[string]*keyLock
+	done    chan struct{}
+	stopped bool
+	cleaner sync.WaitGroup
+	now     func() time.Time
+}
+
+func NewMiddleware(cfg config.Idempotency, storage storage) *middleware {
+	m := &middleware{
+		storage: storage,
+		cfg:     cfg,
+		locks:   map[string]*keyLock{},
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}
+
+	m.cleaner.Add(1)
+	go m.clean()
+	return m
+}
+
+// Stop останавливает удаление устаревших ключей
+func (m *middleware) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.stopped {
+		m.stopped = true
+		close(m.done)
+	}
+	m.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		m.cleaner.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *middleware) Wrap(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			return next(c)
+		}
+		if len(key) > maxKeyLength {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": "idempotency key is too long"})
+		}
+
+		fingerprint, err := requestFingerprint(c.Request())
+		if err != nil {
+			log.Err(err).Msg("failed to parse request")
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": "failed to parse request"})
+		}
+
+		id := c.Request().Header.Get("X-User-Name") + "\x00" + key
+		unlock := m.lock(id)
+		defer unlock()
+
+		record, err := m.storage.Get(id)
+		switch {
+		case err == nil && m.now().Sub(record.CreatedAt) < m.cfg.TTL:
+			if record.Fingerprint != fingerprint {
+				return c.JSON(http.StatusUnprocessableEntity, echo.Map{"message": "idempotency key is already used for another request"})
+			}
+			c.Response().Header().Set(HeaderReplayed, "true")
+			if len(record.Body) == 0 {
+				return c.NoContent(record.StatusCode)
+			}
+			return c.Blob(record.StatusCode, record.ContentType, record.Body)
+		case err != nil && !errors.Is(err, errRecordNotFound):
+			log.Err(err).Msg("failed to get idempotency record")
+			return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		}
+
+		writer := &bodyRecorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = writer
+		err = next(c)
+		c.Response().Writer = writer.ResponseWriter
+		if err != nil || c.Response().Status >= http.StatusInternalServerError {
+			return err
+		}
+
+		err = m.storage.Put(id, Record{
+			Fingerprint: fingerprint,
+			StatusCode:  c.Response().Status,
+			ContentType: c.Response().Header().Get(echo.HeaderContentType),
+			Body:        writer.body.Bytes(),
+			CreatedAt:   m.now(),
+		})
+		if err != nil {
+			// ответ уже отправлен, повтор запроса выполнится заново
+			log.Err(err).Msg("failed to save idempotency record")
+		}
+		return nil
+	}
+}
+
+func (m *middleware) lock(id string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[id]
+	if !ok {
+		l = &keyLock{}
+		m.locks[id] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, id)
+		}
+	}
+}
+
+// clean раз в cleanup_interval удаляет ключи старше ttl
+func (m *middleware) clean() {
+	defer m.cleaner.Done()
+
+	ticker := time.NewTicker(m.cfg.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			deleted, err := m.storage.DeleteCreatedBefore(m.now().Add(-m.cfg.TTL))
+			if err != nil {
+				log.Err(err).Msg("failed to delete expired idempotency records")
+				continue
+			}
+			if deleted > 0 {
+				log.Info().Msgf("deleted %d expired idempotency records", deleted)
+			}
+		}
+	}
+}
+
+// requestFingerprint - хэш метода, пути и тела запроса. Тело читается и подменяется копией.
+func requestFingerprint(req *http.Request) (string, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// bodyRecorder копирует тело ответа для сохранения
+type bodyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,188 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestMiddleware(t *testing.T) (*middleware, *boltStorage) {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "idempotency.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close() })
+
+	m := NewMiddleware(config.Idempotency{TTL: time.Hour, CleanupInterval: time.Hour}, storage)
+	t.Cleanup(func() { _ = m.Stop(context.Background()) })
+	return m, storage
+}
+
+// countingHandler отвечает 201 с номером вызова
+func countingHandler(calls *atomic.Int32, status int) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		n := calls.Add(1)
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		return c.JSON(status, echo.Map{"call": n, "body": string(body)})
+	}
+}
+
+func serve(e *echo.Echo, handler echo.HandlerFunc, path, user, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("X-User-Name", user)
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	rw := httptest.NewRecorder()
+	_ = handler(e.NewContext(req, rw))
+	return rw
+}
+
+func Test_Wrap(t *testing.T) {
+	type request struct {
+		path, user, key, body string
+	}
+
+	tests := []struct {
+		name       string
+		status     int
+		requests   []request
+		wantCalls  int32
+		wantStatus []int
+		replayed   []bool
+	}{
+		{
+			name:       "without key",
+			status:     http.StatusCreated,
+			requests:   []request{{"/reservations", "Test Max", "", "{}"}, {"/reservations", "Test Max", "", "{}"}},
+			wantCalls:  2,
+			wantStatus: []int{http.StatusCreated, http.StatusCreated},
+			replayed:   []bool{false, false},
+		},
+		{
+			name:       "duplicate is replayed",
+			status:     http.StatusCreated,
+			requests:   []request{{"/reservations", "Test Max", "key", "{}"}, {"/reservations", "Test Max", "key", "{}"}},
+			wantCalls:  1,
+			wantStatus: []int{http.StatusCreated, http.StatusCreated},
+			replayed:   []bool{false, true},
+		},
+		{
+			name:       "keys are scoped by user",
+			status:     http.StatusCreated,
+			requests:   []request{{"/reservations", "Test Max", "key", "{}"}, {"/reservations", "Test Min", "key", "{}"}},
+			wantCalls:  2,
+			wantStatus: []int{http.StatusCreated, http.StatusCreated},
+			replayed:   []bool{false, false},
+		},
+		{
+			name:       "key reused for another request",
+			status:     http.StatusCreated,
+			requests:   []request{{"/reservations", "Test Max", "key", "{}"}, {"/reservations", "Test Max", "key", `{"bookUid":"other"}`}},
+			wantCalls:  1,
+			wantStatus: []int{http.StatusCreated, http.StatusUnprocessableEntity},
+			replayed:   []bool{false, false},
+		},
+		{
+			name:       "server error is not stored",
+			status:     http.StatusServiceUnavailable,
+			requests:   []request{{"/reservations", "Test Max", "key", "{}"}, {"/reservations", "Test Max", "key", "{}"}},
+			wantCalls:  2,
+			wantStatus: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			replayed:   []bool{false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			m, _ := newTestMiddleware(t)
+			var calls atomic.Int32
+			handler := m.Wrap(countingHandler(&calls, tt.status))
+
+			var first string
+			for i, r := range tt.requests {
+				rw := serve(e, handler, r.path, r.user, r.key, r.body)
+
+				require.Equal(t, tt.wantStatus[i], rw.Code)
+				require.Equal(t, tt.replayed[i], rw.Header().Get(HeaderReplayed) == "true")
+				if i == 0 {
+					first = rw.Body.String()
+				} else if tt.replayed[i] {
+					require.Equal(t, first, rw.Body.String())
+					require.Equal(t, echo.MIMEApplicationJSON, rw.Header().Get(echo.HeaderContentType))
+				}
+			}
+			require.Equal(t, tt.wantCalls, calls.Load())
+		})
+	}
+}
+
+func Test_WrapSerializesConcurrentDuplicates(t *testing.T) {
+	e := echo.New()
+	m, _ := newTestMiddleware(t)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := m.Wrap(func(c echo.Context) error {
+		calls.Add(1)
+		<-release
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	const requests = 5
+	var wg sync.WaitGroup
+	codes := make([]int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = serve(e, handler, "/reservations/test/return", "Test Max", "key", "{}").Code
+		}(i)
+	}
+
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls.Load())
+	for _, code := range codes {
+		require.Equal(t, http.StatusNoContent, code)
+	}
+	require.Empty(t, m.locks)
+}
+
+func Test_WrapIgnoresExpiredRecords(t *testing.T) {
+	e := echo.New()
+	m, storage := newTestMiddleware(t)
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	var calls atomic.Int32
+	handler := m.Wrap(countingHandler(&calls, http.StatusCreated))
+
+	serve(e, handler, "/reservations", "Test Max", "key", "{}")
+	now = now.Add(2 * time.Hour)
+	rw := serve(e, handler, "/reservations", "Test Max", "key", "{}")
+
+	require.Equal(t, int32(2), calls.Load())
+	require.Empty(t, rw.Header().Get(HeaderReplayed))
+
+	deleted, err := storage.DeleteCreatedBefore(now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+	deleted, err = storage.DeleteCreatedBefore(now.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+}
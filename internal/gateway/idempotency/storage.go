@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	storageOpenTimeout = time.Second
+	storageFileMode    = 0o600
+	storageDirMode     = 0o700
+)
+
+var recordBucket = []byte("idempotency_keys")
+
+// Record - первый ответ на запрос с ключом идемпотентности. Fingerprint отличает повтор запроса
+// от другого запроса с тем же ключом.
+type Record struct {
+	Fingerprint string    `json:"fingerprint"`
+	StatusCode  int       `json:"statusCode"`
+	ContentType string    `json:"contentType,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// NewStorage открывает (или создает) файл ключей идемпотентности по пути path
+func NewStorage(path string) (*boltStorage, error) {
+	err := os.MkdirAll(filepath.Dir(path), storageDirMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create idempotency storage dir")
+	}
+
+	db, err := bolt.Open(path, storageFileMode, &bolt.Options{Timeout: storageOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open idempotency storage")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(recordBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "failed to create idempotency bucket")
+	}
+
+	return &boltStorage{db: db}, nil
+}
+
+func (s *boltStorage) Get(key string) (Record, error) {
+	var record Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(recordBucket).Get([]byte(key))
+		if value == nil {
+			return errRecordNotFound
+		}
+		return json.Unmarshal(value, &record)
+	})
+	if err != nil {
+		return Record{}, errors.Wrap(err, "failed to get idempotency record")
+	}
+	return record, nil
+}
+
+func (s *boltStorage) Put(key string, record Record) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal idempotency record")
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordBucket).Put([]byte(key), value)
+	})
+	return errors.Wrap(err, "failed to put idempotency record")
+}
+
+// DeleteCreatedBefore удаляет записи, созданные раньше before, и возвращает их число
+func (s *boltStorage) DeleteCreatedBefore(before time.Time) (int, error) {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(recordBucket)
+
+		var expired [][]byte
+		err := b.ForEach(func(key, value []byte) error {
+			var record Record
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return err
+			}
+			if record.CreatedAt.Before(before) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// удалять записи во время обхода ForEach нельзя
+		for _, key := range expired {
+			err = b.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired idempotency records")
+	}
+	return deleted, nil
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
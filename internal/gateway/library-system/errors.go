@@ -0,0 +1,53 @@
+package library_system
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	errNotOkStatusCode = errors.New("not ok status code")
+	errInvalidRequest  = errors.New("invalid request")
+)
+
+// statusCodeError - ответ сервиса с неожидаемым кодом, errors.Is(err, errNotOkStatusCode) для него истинно
+type statusCodeError struct {
+	statusCode int
+}
+
+func newStatusCodeError(statusCode int) error {
+	return &statusCodeError{statusCode: statusCode}
+}
+
+func (e *statusCodeError) Error() string {
+	return fmt.Sprintf("%s: status code = %d", errNotOkStatusCode, e.statusCode)
+}
+
+func (e *statusCodeError) Is(target error) bool {
+	return target == errNotOkStatusCode
+}
+
+// isUpstreamFailure сообщает, говорит ли ошибка о неработоспособности сервиса.
+// Ответы 4xx - это ошибки запроса, а не сервиса, поэтому circuit breaker их не учитывает.
+func isUpstreamFailure(err error) bool {
+	var statusErr *statusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// operationError - ошибка операции operation из circuitBreakerUpstreams, по ней повтор определяет отказавший сервис
+type operationError struct {
+	operation string
+	err       error
+}
+
+func (e *operationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *operationError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,69 @@
+package library_system
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+var (
+	errLibraryServiceUnavailable     = errors.New("Library Service unavailable")
+	errReservationServiceUnavailable = errors.New("Reservation Service unavailable")
+	errBonusServiceUnavailable       = errors.New("Bonus Service unavailable")
+)
+
+// fallbacks - политика деградации каждой операции, вызывается, если операция завершилась ошибкой
+// или была отклонена circuit breaker'ом. Nil-результат означает, что запрос обслуживается без данных операции.
+var fallbacks = map[string]func(err error) error{
+	"getBooksByUids":          withoutDetails("getBooksByUids"),
+	"getReservedCopy":         withoutDetails("getReservedCopy"),
+	"getBooksByLibrary":       unavailable(errLibraryServiceUnavailable),
+	"searchBooks":             unavailable(errLibraryServiceUnavailable),
+	"getLibrariesByUids":      withoutDetails("getLibrariesByUids"),
+	"getLibraries":            unavailable(errLibraryServiceUnavailable),
+	"adminRequest":            unavailable(errLibraryServiceUnavailable),
+	"updateAvailableCount":    unavailable(errLibraryServiceUnavailable),
+	"updateBookCondition":     unavailable(errLibraryServiceUnavailable),
+	"getReservationsByUser":   unavailable(errReservationServiceUnavailable),
+	"getReservationsByUid":    unavailable(errReservationServiceUnavailable),
+	"createReservation":       unavailable(errReservationServiceUnavailable),
+	"updateReservationStatus": unavailable(errReservationServiceUnavailable),
+	"deleteReservation":       unavailable(errReservationServiceUnavailable),
+	"getRatingByUser":         unavailable(errBonusServiceUnavailable),
+	"getRatingHistory":        unavailable(errBonusServiceUnavailable),
+	"createUser":              unavailable(errBonusServiceUnavailable),
+	"updateUserRating":        unavailable(errBonusServiceUnavailable),
+}
+
+// withoutDetails глушит ошибку: подробности о книгах и библиотеках необязательны, без них ответ содержит только uid
+func withoutDetails(operation string) func(err error) error {
+	return func(err error) error {
+		log.Warn().Err(err).Str("operation", operation).Msg("responding without details")
+		return nil
+	}
+}
+
+// unavailable помечает отказ сервиса ошибкой errUnavailable, ошибки запроса (4xx) возвращаются как есть
+func unavailable(errUnavailable error) func(err error) error {
+	return func(err error) error {
+		if !isUpstreamFailure(err) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errUnavailable, err)
+	}
+}
+
+// call выполняет операцию через ее circuit breaker с fallback'ом из fallbacks
+func (h *handler) call(operation string, f func() error) error {
+	return h.circuitBreakers[operation].CallWithFallback(f, fallbacks[operation])
+}
+
+// unavailableResponse - ответ клиенту при отказе сервиса: для бонусного сервиса 503, для остальных 500
+func unavailableResponse(c echo.Context, err error) error {
+	if errors.Is(err, errBonusServiceUnavailable) {
+		return c.JSON(http.StatusServiceUnavailable, echo.Map{"message": errBonusServiceUnavailable.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+}
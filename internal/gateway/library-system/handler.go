@@ -6,6 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/retry"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/saga"
+	"github.com/Erlendum/rsoi-lab-03/pkg/auth"
 	circuit_breaker "github.com/Erlendum/rsoi-lab-03/pkg/circuit-breaker"
 	my_time "github.com/Erlendum/rsoi-lab-03/pkg/time"
 	"github.com/labstack/echo/v4"
@@ -14,6 +17,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,54 +32,166 @@ type httpClient interface {
 
 type circuitBreaker interface {
 	Call(operation func() error) error
+	CallWithFallback(operation func() error, fallback func(err error) error) error
+	Name() string
+	Snapshot() circuit_breaker.Snapshot
+	ForceOpen()
+	ForceClose()
+	Reset()
 }
 
 type handler struct {
 	httpClient      httpClient
 	config          *config.Config
 	circuitBreakers map[string]circuitBreaker
-	retryHandler    *retryHandler
+	retryQueue      retryQueue
+	sagas           sagaOrchestrator
+	idempotency     idempotencyMiddleware
+}
+
+type sagaOrchestrator interface {
+	Execute(sagaType string, state any) error
+	Handle(definitions map[string]saga.Definition)
+}
+
+type idempotencyMiddleware interface {
+	Wrap(next echo.HandlerFunc) echo.HandlerFunc
+}
+
+type retryQueue interface {
+	Publish(cmd retry.Command) error
+	Handle(executors map[string]func(cmd retry.Command) error)
 }
 
+const (
+	returnBookOperation = "returnBook"
+)
+
 const (
 	rentedStatus   = "RENTED"
 	expiredStatus  = "EXPIRED"
 	returnedStatus = "RETURNED"
 )
 
+// штрафы и бонус рейтинга при возврате книги
+const (
+	latePenalty      = 10
+	conditionPenalty = 10
+	returnBonus      = 1
+)
+
+// conditionWorsenedReason - причина штрафа за возврат книги в худшем состоянии
+const conditionWorsenedReason = "CONDITION_WORSENED"
+
 var (
-	errNotOkStatusCode = errors.New("not ok status code")
-	conditionMap       = map[string]int{
+	conditionMap = map[string]int{
 		"BAD":       1,
 		"GOOD":      2,
 		"EXCELLENT": 3,
 	}
 )
 
-func NewHandler(config *config.Config) *handler {
+type circuitBreakerObserver interface {
+	Options(name string) []circuit_breaker.Option
+}
+
+const (
+	librarySystem     = "library"
+	reservationSystem = "reservation"
+	ratingSystem      = "rating"
+)
+
+// circuitBreakerUpstreams - операции, защищаемые circuit breaker'ами, и сервисы, к которым они обращаются
+var circuitBreakerUpstreams = map[string]string{
+	"getBooksByUids":          librarySystem,
+	"getReservedCopy":         librarySystem,
+	"getBooksByLibrary":       librarySystem,
+	"searchBooks":             librarySystem,
+	"getLibrariesByUids":      librarySystem,
+	"getLibraries":            librarySystem,
+	"adminRequest":            librarySystem,
+	"updateAvailableCount":    librarySystem,
+	"updateBookCondition":     librarySystem,
+	"getReservationsByUser":   reservationSystem,
+	"getReservationsByUid":    reservationSystem,
+	"createReservation":       reservationSystem,
+	"updateReservationStatus": reservationSystem,
+	"deleteReservation":       reservationSystem,
+	"getRatingByUser":         ratingSystem,
+	"getRatingHistory":        ratingSystem,
+	"createUser":              ratingSystem,
+	"updateUserRating":        ratingSystem,
+}
+
+func NewHandler(config *config.Config, observer circuitBreakerObserver, retryQueue retryQueue, sagas sagaOrchestrator, idempotency idempotencyMiddleware) *handler {
 	h := &handler{
 		httpClient: &http.Client{
 			Timeout:   defaultTimeout,
 			Transport: &http.Transport{MaxConnsPerHost: defaultMaxConnsPerHost},
 		},
-		config: config,
-		circuitBreakers: map[string]circuitBreaker{
-			"getBooksByUids":        circuit_breaker.New(config.CircuitBreaker.MaxFailures, config.CircuitBreaker.ResetTimeout),
-			"getBooksByLibrary":     circuit_breaker.New(config.CircuitBreaker.MaxFailures, config.CircuitBreaker.ResetTimeout),
-			"getLibrariesByUids":    circuit_breaker.New(config.CircuitBreaker.MaxFailures, config.CircuitBreaker.ResetTimeout),
-			"getReservationsByUser": circuit_breaker.New(config.CircuitBreaker.MaxFailures, config.CircuitBreaker.ResetTimeout),
-			"getReservationsByUid":  circuit_breaker.New(config.CircuitBreaker.MaxFailures, config.CircuitBreaker.ResetTimeout),
-			"getRatingByUser":       circuit_breaker.New(config.CircuitBreaker.MaxFailures, config.CircuitBreaker.ResetTimeout),
-			"getLibraries":          circuit_breaker.New(config.CircuitBreaker.MaxFailures, config.CircuitBreaker.ResetTimeout),
-		},
-		retryHandler: NewRetryHandler(),
+		config:          config,
+		circuitBreakers: newCircuitBreakers(config.CircuitBreaker, observer),
+		retryQueue:      retryQueue,
+		sagas:           sagas,
+		idempotency:     idempotency,
 	}
 
-	h.retryHandler.Handle()
+	executors := h.returnBookStepExecutors()
+	executors[returnBookOperation] = h.retryReturnBook
+	h.retryQueue.Handle(executors)
+
+	// восстановление саг может поставить шаги в очередь повторов, поэтому запускается после нее
+	h.sagas.Handle(h.sagaDefinitions())
 
 	return h
 }
 
+// newCircuitBreakers создает breaker на каждую операцию или, если scope = upstream,
+// один общий breaker на каждый сервис, чтобы отказ сервиса обнаруживался один раз для всех его операций.
+func newCircuitBreakers(cfg config.CircuitBreaker, observer circuitBreakerObserver) map[string]circuitBreaker {
+	circuitBreakers := make(map[string]circuitBreaker, len(circuitBreakerUpstreams))
+	shared := map[string]circuitBreaker{}
+	for operation, upstream := range circuitBreakerUpstreams {
+		if cfg.Scope != config.CircuitBreakerScopeUpstream {
+			circuitBreakers[operation] = newCircuitBreaker(cfg, operation, observer.Options(operation)...)
+			continue
+		}
+
+		cb, ok := shared[upstream]
+		if !ok {
+			cb = newCircuitBreaker(cfg, upstream, observer.Options(upstream)...)
+			shared[upstream] = cb
+		}
+		circuitBreakers[operation] = cb
+	}
+	return circuitBreakers
+}
+
+func (h *handler) CircuitBreakers() map[string]circuitBreaker {
+	return h.circuitBreakers
+}
+
+func newCircuitBreaker(cfg config.CircuitBreaker, name string, opts ...circuit_breaker.Option) circuitBreaker {
+	cfg = cfg.For(name)
+
+	opts = append(opts,
+		circuit_breaker.WithName(name),
+		circuit_breaker.WithHalfOpenMaxCalls(cfg.HalfOpenMaxCalls),
+		circuit_breaker.WithIsFailure(isUpstreamFailure),
+		circuit_breaker.WithBackoff(cfg.BackoffMultiplier, cfg.MaxResetTimeout, cfg.ResetJitter),
+	)
+	if cfg.Mode == config.CircuitBreakerModeFailureRate {
+		switch cfg.WindowType {
+		case config.CircuitBreakerWindowCount:
+			opts = append(opts, circuit_breaker.WithCountWindow(cfg.WindowSize, cfg.FailureRateThreshold, cfg.MinimumRequests))
+		case config.CircuitBreakerWindowTime:
+			opts = append(opts, circuit_breaker.WithTimeWindow(cfg.WindowDuration, cfg.FailureRateThreshold, cfg.MinimumRequests))
+		}
+	}
+
+	return circuit_breaker.New(cfg.MaxFailures, cfg.ResetTimeout, opts...)
+}
+
 func compareConditions(a, b string) (int, error) {
 	weightA, okA := conditionMap[a]
 	weightB, okB := conditionMap[b]
@@ -97,10 +213,25 @@ func (h *handler) Register(echo *echo.Echo) {
 
 	api.GET("/libraries", h.GetLibraries)
 	api.GET("/libraries/:libraryUid/books", h.GetBooksByLibrary)
+	api.GET("/books/search", h.SearchBooks)
 	api.GET("/reservations", h.GetBooksByUser)
-	api.POST("/reservations", h.ReserveBookByUser)
-	api.POST("/reservations/:reservationUid/return", h.ReturnBookByUser)
+	api.POST("/reservations", h.ReserveBookByUser, h.idempotency.Wrap)
+	api.POST("/reservations/:reservationUid/return", h.ReturnBookByUser, h.idempotency.Wrap)
 	api.GET("/rating", h.GetRatingByUser)
+	api.GET("/rating/history", h.GetRatingHistory)
+
+	// токен проверяется и здесь, и в сервисе библиотек, чтобы без него запросы не доходили до сервиса
+	admin := api.Group("/admin", auth.AdminToken(h.config.Admin.Token))
+	admin.POST("/libraries", h.AdminProxy)
+	admin.PUT("/libraries/:libraryUid", h.AdminProxy)
+	admin.DELETE("/libraries/:libraryUid", h.AdminProxy)
+	admin.POST("/books", h.AdminProxy)
+	admin.PUT("/books/:bookUid", h.AdminProxy)
+	admin.DELETE("/books/:bookUid", h.AdminProxy)
+	admin.PUT("/libraries/:libraryUid/books/:bookUid", h.AdminProxy)
+	admin.DELETE("/libraries/:libraryUid/books/:bookUid", h.AdminProxy)
+	admin.POST("/catalog/import", h.AdminProxy)
+	admin.GET("/catalog/export", h.AdminProxy)
 }
 
 func (h *handler) getLibraries(city, page, size string) (int, []byte, error) {
@@ -130,6 +261,10 @@ func (h *handler) getLibraries(city, page, size string) (int, []byte, error) {
 		return 0, nil, err
 	}
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
+	}
+
 	return resp.StatusCode, body, nil
 }
 
@@ -137,13 +272,66 @@ func (h *handler) GetLibraries(c echo.Context) error {
 	var statusCode int
 	var body []byte
 	var err error
-	err = h.circuitBreakers["getLibraries"].Call(func() error {
+	err = h.call("getLibraries", func() error {
 		statusCode, body, err = h.getLibraries(c.QueryParam("city"), c.QueryParam("page"), c.QueryParam("size"))
 		return err
 	})
 	if err != nil {
 		log.Err(err).Msg("failed to process request to library service")
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return unavailableResponse(c, err)
+	}
+
+	c.Response().Header().Set("Content-Type", "application/json")
+	return c.String(statusCode, string(body))
+}
+
+func (h *handler) searchBooks(query, city, available, page, size string) (int, []byte, error) {
+	queryParams := url.Values{}
+	queryParams.Add("query", query)
+	queryParams.Add("city", city)
+	queryParams.Add("available", available)
+	queryParams.Add("page", page)
+	queryParams.Add("size", size)
+	reqURL, err := url.Parse(h.config.LibrarySystemURL + "/books/search")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	reqURL.RawQuery = queryParams.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+func (h *handler) SearchBooks(c echo.Context) error {
+	var statusCode int
+	var body []byte
+	var err error
+	err = h.call("searchBooks", func() error {
+		statusCode, body, err = h.searchBooks(c.QueryParam("query"), c.QueryParam("city"), c.QueryParam("available"), c.QueryParam("page"), c.QueryParam("size"))
+		return err
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to process request to library service")
+		return unavailableResponse(c, err)
 	}
 
 	c.Response().Header().Set("Content-Type", "application/json")
@@ -177,6 +365,10 @@ func (h *handler) getBooksByLibrary(page, size, showAll, libraryUid string) (int
 		return 0, nil, err
 	}
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
+	}
+
 	return resp.StatusCode, body, nil
 }
 
@@ -184,13 +376,13 @@ func (h *handler) GetBooksByLibrary(c echo.Context) error {
 	var statusCode int
 	var body []byte
 	var err error
-	err = h.circuitBreakers["getBooksByLibrary"].Call(func() error {
+	err = h.call("getBooksByLibrary", func() error {
 		statusCode, body, err = h.getBooksByLibrary(c.QueryParam("page"), c.QueryParam("size"), c.QueryParam("showAll"), c.Param("libraryUid"))
 		return err
 	})
 	if err != nil {
 		log.Err(err).Msg("failed to process request to library service")
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return unavailableResponse(c, err)
 	}
 
 	c.Response().Header().Set("Content-Type", "application/json")
@@ -235,6 +427,10 @@ func (h *handler) getBooksByUids(uids []string) (map[string]bookResp, error) {
 		return nil, err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusCodeError(resp.StatusCode)
+	}
+
 	var booksRespData booksResp
 	err = json.Unmarshal(body, &booksRespData)
 	if err != nil {
@@ -286,6 +482,10 @@ func (h *handler) getLibrariesByUids(uids []string) (map[string]libraryResp, err
 		return nil, err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusCodeError(resp.StatusCode)
+	}
+
 	var librariesRespData librariesResp
 	err = json.Unmarshal(body, &librariesRespData)
 	if err != nil {
@@ -335,7 +535,7 @@ func (h *handler) getReservationsByUser(userName string) ([]reservationResp, int
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, resp.StatusCode, errors.Join(errNotOkStatusCode, fmt.Errorf("status code: %d", resp.StatusCode))
+		return nil, resp.StatusCode, newStatusCodeError(resp.StatusCode)
 	}
 
 	var reservations []reservationResp
@@ -365,7 +565,7 @@ func (h *handler) getReservationsByUid(uid string) (int, []byte, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, body, errors.Join(errNotOkStatusCode, fmt.Errorf("status code = %d", resp.StatusCode))
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
 	}
 
 	return resp.StatusCode, body, nil
@@ -375,7 +575,7 @@ func (h *handler) GetBooksByUser(c echo.Context) error {
 	var reservations []reservationResp
 	var statusCode int
 	var err error
-	err = h.circuitBreakers["getReservationsByUser"].Call(func() error {
+	err = h.call("getReservationsByUser", func() error {
 		reservations, statusCode, err = h.getReservationsByUser(c.Request().Header.Get("X-User-Name"))
 		return err
 	})
@@ -384,7 +584,7 @@ func (h *handler) GetBooksByUser(c echo.Context) error {
 		if errors.Is(err, errNotOkStatusCode) {
 			return c.JSON(statusCode, echo.Map{"message": err.Error()})
 		}
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return unavailableResponse(c, err)
 	}
 
 	booksUids := make([]string, 0, len(reservations))
@@ -394,26 +594,21 @@ func (h *handler) GetBooksByUser(c echo.Context) error {
 		librariesUids = append(librariesUids, r.LibraryUid)
 	}
 
+	// подробности о книгах и библиотеках необязательны: без них ответ содержит только uid
 	var booksMap map[string]bookResp
-	err = h.circuitBreakers["getBooksByUids"].Call(func() error {
+	_ = h.call("getBooksByUids", func() error {
 		booksMap, err = h.getBooksByUids(booksUids)
 		return err
 	})
 
-	// fallback-ответ только с uid книг и библиотек, без подробной информации о них
-	if err != nil {
-		log.Err(err).Msg("failed to process request to library service")
-		return c.JSON(http.StatusOK, reservations)
-	}
-
 	var librariesMap map[string]libraryResp
-	err = h.circuitBreakers["getLibrariesByUids"].Call(func() error {
+	_ = h.call("getLibrariesByUids", func() error {
 		librariesMap, err = h.getLibrariesByUids(librariesUids)
 		return err
 	})
+
 	// fallback-ответ только с uid книг и библиотек, без подробной информации о них
-	if err != nil {
-		log.Err(err).Msg("failed to process request to library service")
+	if booksMap == nil || librariesMap == nil {
 		return c.JSON(http.StatusOK, reservations)
 	}
 
@@ -467,7 +662,7 @@ func (h *handler) createUser(userName string) (int, []byte, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, body, errNotOkStatusCode
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
 	}
 
 	return resp.StatusCode, body, nil
@@ -491,7 +686,7 @@ func (h *handler) createReservation(reqBody []byte, userName string) (int, []byt
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, body, errNotOkStatusCode
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
 	}
 
 	return resp.StatusCode, body, nil
@@ -509,7 +704,7 @@ func (h *handler) deleteReservation(reservationUid string) (int, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, errNotOkStatusCode
+		return resp.StatusCode, newStatusCodeError(resp.StatusCode)
 	}
 
 	return resp.StatusCode, nil
@@ -519,7 +714,7 @@ func (h *handler) ReserveBookByUser(c echo.Context) error {
 	var reservations []reservationResp
 	var statusCode int
 	var err error
-	err = h.circuitBreakers["getReservationsByUser"].Call(func() error {
+	err = h.call("getReservationsByUser", func() error {
 		reservations, statusCode, err = h.getReservationsByUser(c.Request().Header.Get("X-User-Name"))
 		return err
 	})
@@ -528,17 +723,17 @@ func (h *handler) ReserveBookByUser(c echo.Context) error {
 		if errors.Is(err, errNotOkStatusCode) {
 			return c.JSON(statusCode, echo.Map{"message": err.Error()})
 		}
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return unavailableResponse(c, err)
 	}
 
 	var body []byte
-	err = h.circuitBreakers["getRatingByUser"].Call(func() error {
+	err = h.call("getRatingByUser", func() error {
 		statusCode, body, err = h.getRatingByUser(c.Request().Header.Get("X-User-Name"))
 		return err
 	})
 	if err != nil && !errors.Is(err, errNotOkStatusCode) {
 		log.Err(err).Msg("failed to process request to rating service")
-		return c.JSON(http.StatusServiceUnavailable, echo.Map{"message": "Bonus Service unavailable"})
+		return unavailableResponse(c, err)
 	}
 
 	if statusCode != http.StatusOK && statusCode != http.StatusNotFound {
@@ -548,13 +743,16 @@ func (h *handler) ReserveBookByUser(c echo.Context) error {
 	stars := 0
 	// если пользователь не найден, создаем его
 	if statusCode == http.StatusNotFound {
-		statusCode, body, err = h.createUser(c.Request().Header.Get("X-User-Name"))
+		err = h.call("createUser", func() error {
+			statusCode, body, err = h.createUser(c.Request().Header.Get("X-User-Name"))
+			return err
+		})
 		if err != nil {
 			log.Err(err).Msg("failed to process request to rating service")
 			if errors.Is(err, errNotOkStatusCode) {
 				return c.String(statusCode, string(body))
 			}
-			return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+			return unavailableResponse(c, err)
 		}
 		type createUserResp struct {
 			ID       int    `json:"id"`
@@ -592,29 +790,27 @@ func (h *handler) ReserveBookByUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"message": "failed to parse request"})
 	}
 
-	statusCode, body, err = h.createReservation(reqBody, c.Request().Header.Get("X-User-Name"))
+	reservation := &reserveBookState{UserName: c.Request().Header.Get("X-User-Name"), ReqBody: reqBody}
+	err = h.sagas.Execute(reserveBookSaga, reservation)
 	if err != nil {
-		log.Err(err).Msg("failed to process request to reservation service")
-		if errors.Is(err, errNotOkStatusCode) {
-			return c.String(statusCode, string(body))
+		log.Err(err).Msg("failed to reserve book")
+		if errors.Is(err, errNotOkStatusCode) && !errors.Is(err, saga.ErrCompensationPending) {
+			return c.String(reservation.statusCode, string(reservation.body))
 		}
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return unavailableResponse(c, err)
 	}
+	createdReservation := reservation.Reservation
 
-	type createReservationResp struct {
-		ReservationUid string `json:"reservationUid"`
-		Status         string `json:"status"`
-		StartDate      string `json:"startDate"`
-		TillDate       string `json:"tillDate"`
-		BookUid        string `json:"bookUid"`
-		LibraryUid     string `json:"libraryUid"`
-	}
-
-	createdReservation := createReservationResp{}
-	err = json.Unmarshal(body, &createdReservation)
-	if err != nil {
-		log.Err(err).Msg("failed to process request to reservation service")
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+	type response struct {
+		ReservationUid string      `json:"reservationUid"`
+		Status         string      `json:"status"`
+		StartDate      string      `json:"startDate"`
+		TillDate       string      `json:"tillDate"`
+		Book           bookResp    `json:"book"`
+		Library        libraryResp `json:"library"`
+		Rating         struct {
+			Stars int `json:"stars"`
+		} `json:"rating"`
 	}
 
 	type fallbackResponse struct {
@@ -629,105 +825,61 @@ func (h *handler) ReserveBookByUser(c echo.Context) error {
 		} `json:"rating"`
 	}
 
-	statusCode, body, err = h.updateAvailableCount(createdReservation.LibraryUid, createdReservation.BookUid, -1)
-	// откат + возврат в очередь
-	if err != nil {
-		log.Err(err).Msg("failed to process request to library service")
-		statusCode, err = h.deleteReservation(createdReservation.ReservationUid)
-		if err != nil {
-			log.Err(err).Msg("failed to process request to reservation service")
-			return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
-		}
-		return c.JSON(http.StatusOK, fallbackResponse{
-			ReservationUid: createdReservation.ReservationUid,
-			Status:         createdReservation.Status,
-			StartDate:      createdReservation.StartDate,
-			TillDate:       createdReservation.TillDate,
-			BookUid:        createdReservation.BookUid,
-			LibraryUid:     createdReservation.LibraryUid,
-			Rating: struct {
-				Stars int `json:"stars"`
-			}{
-				Stars: stars,
-			},
-		})
-	}
-
-	type response struct {
-		ReservationUid string      `json:"reservationUid"`
-		Status         string      `json:"status"`
-		StartDate      string      `json:"startDate"`
-		TillDate       string      `json:"tillDate"`
-		Book           bookResp    `json:"book"`
-		Library        libraryResp `json:"library"`
-		Rating         struct {
-			Stars int `json:"stars"`
-		} `json:"rating"`
-	}
-
+	// подробности о книге и библиотеке необязательны: при отказе library service ответ содержит только их uid
 	var books map[string]bookResp
-	err = h.circuitBreakers["getBooksByUids"].Call(func() error {
+	_ = h.call("getBooksByUids", func() error {
 		books, err = h.getBooksByUids([]string{createdReservation.BookUid})
 		return err
 	})
-	// fallback-ответ только с uid книг и библиотек, без подробной информации о них
-	if err != nil {
-		log.Err(err).Msg("failed to process request to library service")
-		return c.JSON(http.StatusOK, fallbackResponse{
-			ReservationUid: createdReservation.ReservationUid,
-			Status:         createdReservation.Status,
-			StartDate:      createdReservation.StartDate,
-			TillDate:       createdReservation.TillDate,
-			BookUid:        createdReservation.BookUid,
-			LibraryUid:     createdReservation.LibraryUid,
-			Rating: struct {
-				Stars int `json:"stars"`
-			}{
-				Stars: stars,
-			},
-		})
-	}
 
 	var libraries map[string]libraryResp
-	err = h.circuitBreakers["getLibrariesByUids"].Call(func() error {
+	_ = h.call("getLibrariesByUids", func() error {
 		libraries, err = h.getLibrariesByUids([]string{createdReservation.LibraryUid})
 		return err
 	})
-	// fallback-ответ только с uid книг и библиотек, без подробной информации о них
-	if err != nil {
-		log.Err(err).Msg("failed to process request to library service")
-		return c.JSON(http.StatusOK, fallbackResponse{
+
+	if books == nil || libraries == nil {
+		resp := fallbackResponse{
 			ReservationUid: createdReservation.ReservationUid,
 			Status:         createdReservation.Status,
 			StartDate:      createdReservation.StartDate,
 			TillDate:       createdReservation.TillDate,
 			BookUid:        createdReservation.BookUid,
 			LibraryUid:     createdReservation.LibraryUid,
-			Rating: struct {
-				Stars int `json:"stars"`
-			}{
-				Stars: stars,
-			},
-		})
+		}
+		resp.Rating.Stars = stars
+		return c.JSON(http.StatusOK, resp)
 	}
 
-	return c.JSON(http.StatusOK, response{
+	resp := response{
 		ReservationUid: createdReservation.ReservationUid,
 		Status:         createdReservation.Status,
 		StartDate:      createdReservation.StartDate,
 		TillDate:       createdReservation.TillDate,
 		Book:           books[createdReservation.BookUid],
 		Library:        libraries[createdReservation.LibraryUid],
-		Rating: struct {
-			Stars int `json:"stars"`
-		}{
-			Stars: stars,
-		},
-	})
+	}
+	resp.Rating.Stars = stars
+	return c.JSON(http.StatusOK, resp)
 }
 
-func (h *handler) updateAvailableCount(libraryUid, bookUid string, countDiff int) (int, []byte, error) {
-	req, err := http.NewRequest(http.MethodPut, h.config.LibrarySystemURL+"/libraries/"+libraryUid+"/books/"+bookUid+"?countDiff="+strconv.Itoa(countDiff), nil)
+// operationId - идентификатор изменения в library и rating service: повтор изменения с тем же
+// идентификатором не применяется, поэтому повторы из очереди безопасны
+func operationId(reservationUid, action string) string {
+	return reservationUid + ":" + action
+}
+
+// updateAvailableCount изменяет число доступных экземпляров книги, выданные экземпляры привязываются к брони reservationUid.
+// returned - возврат книги читателем: книга, выданная до учета экземпляров, возвращается новым экземпляром
+func (h *handler) updateAvailableCount(libraryUid, bookUid string, countDiff int, reservationUid, operationId string, returned bool) (int, []byte, error) {
+	queryParams := url.Values{}
+	queryParams.Set("countDiff", strconv.Itoa(countDiff))
+	queryParams.Set("reservationUid", reservationUid)
+	queryParams.Set("operationId", operationId)
+	if returned {
+		queryParams.Set("returned", "true")
+	}
+	req, err := http.NewRequest(http.MethodPut, h.config.LibrarySystemURL+"/libraries/"+libraryUid+"/books/"+bookUid+"?"+queryParams.Encode(), nil)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -743,7 +895,68 @@ func (h *handler) updateAvailableCount(libraryUid, bookUid string, countDiff int
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, body, errNotOkStatusCode
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+type copyResp struct {
+	CopyUid   string `json:"copyUid"`
+	Condition string `json:"condition"`
+}
+
+// getReservedCopy возвращает экземпляр книги, выданный по брони reservationUid
+func (h *handler) getReservedCopy(reservationUid string) (copyResp, error) {
+	req, err := http.NewRequest(http.MethodGet, h.config.LibrarySystemURL+"/copies/by-reservation/"+reservationUid, nil)
+	if err != nil {
+		return copyResp{}, err
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return copyResp{}, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return copyResp{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return copyResp{}, newStatusCodeError(resp.StatusCode)
+	}
+
+	res := copyResp{}
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return copyResp{}, err
+	}
+
+	return res, nil
+}
+
+// updateBookCondition изменяет состояние экземпляра книги copyUid
+func (h *handler) updateBookCondition(copyUid, condition string) (int, []byte, error) {
+	queryParams := url.Values{}
+	queryParams.Set("condition", condition)
+	req, err := http.NewRequest(http.MethodPut, h.config.LibrarySystemURL+"/copies/"+copyUid+"/condition?"+queryParams.Encode(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
 	}
 
 	return resp.StatusCode, body, nil
@@ -767,14 +980,20 @@ func (h *handler) updateReservationStatus(reservationUid, status, username strin
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, body, errNotOkStatusCode
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
 	}
 
 	return resp.StatusCode, body, nil
 }
 
-func (h *handler) updateUserRating(username string, starsDiff int) (int, []byte, error) {
-	req, err := http.NewRequest(http.MethodPut, h.config.RatingSystemURL+"/rating/"+username+"?starsDiff="+strconv.Itoa(starsDiff), nil)
+// updateUserRating изменяет рейтинг пользователя, reason и reservationUid сохраняются в истории рейтинга
+func (h *handler) updateUserRating(username string, starsDiff int, reason, reservationUid, operationId string) (int, []byte, error) {
+	queryParams := url.Values{}
+	queryParams.Set("starsDiff", strconv.Itoa(starsDiff))
+	queryParams.Set("reason", reason)
+	queryParams.Set("reservationUid", reservationUid)
+	queryParams.Set("operationId", operationId)
+	req, err := http.NewRequest(http.MethodPut, h.config.RatingSystemURL+"/rating/"+username+"?"+queryParams.Encode(), nil)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -790,33 +1009,86 @@ func (h *handler) updateUserRating(username string, starsDiff int) (int, []byte,
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, body, errNotOkStatusCode
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
 	}
 
 	return resp.StatusCode, body, nil
 }
 
 func (h *handler) ReturnBookByUser(c echo.Context) error {
+	reqBody, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		log.Err(err).Msg("failed to parse request")
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "failed to parse request"})
+	}
+
+	cmd := retry.Command{
+		Operation:      returnBookOperation,
+		ReservationUid: c.Param("reservationUid"),
+		UserName:       c.Request().Header.Get("X-User-Name"),
+		Body:           reqBody,
+	}
+
+	statusCode, body, err := h.returnBook(cmd)
+	switch {
+	case err == nil:
+		return c.NoContent(http.StatusNoContent)
+	case errors.Is(err, errInvalidRequest):
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "failed to parse request"})
+	case errors.Is(err, saga.ErrCompensationPending):
+		return unavailableResponse(c, err)
+	case errors.Is(err, errNotOkStatusCode):
+		return c.String(statusCode, string(body))
+	default:
+		return unavailableResponse(c, err)
+	}
+}
+
+// retryReturnBook - повтор всего возврата из очереди для команд, принятых до перехода на повтор отдельных шагов.
+// При отказе сервиса повтор откладывается до его восстановления, остальные ошибки не повторяются.
+func (h *handler) retryReturnBook(cmd retry.Command) error {
+	_, _, err := h.returnBook(cmd)
+	if err != nil && !errors.Is(err, errInvalidRequest) && isUpstreamFailure(err) {
+		return retry.Later(returnBookUpstream(err, cmd.Upstream), err)
+	}
+	return err
+}
+
+// returnBookUpstream возвращает сервис, из-за которого не выполнен возврат, или fallback, если он неизвестен
+func returnBookUpstream(err error, fallback string) string {
+	var opErr *operationError
+	if errors.As(err, &opErr) {
+		return circuitBreakerUpstreams[opErr.operation]
+	}
+	var stepErr *saga.StepError
+	if errors.As(err, &stepErr) {
+		return circuitBreakerUpstreams[stepErr.Step]
+	}
+	return fallback
+}
+
+// returnBook возвращает книгу по команде cmd и не зависит от HTTP-запроса, поэтому выполняется и при повторах.
+// Изменения выполняются сагой returnBookSaga. Если после смены статуса брони library или rating service
+// недоступны, возврат считается выполненным, а неудавшиеся шаги повторяются из очереди.
+// Для ответов сервисов с ошибкой (errNotOkStatusCode) возвращаются их код и тело.
+func (h *handler) returnBook(cmd retry.Command) (int, []byte, error) {
 	var statusCode int
 	var body []byte
 	var err error
-	err = h.circuitBreakers["getReservationsByUid"].Call(func() error {
-		statusCode, body, err = h.getReservationsByUid(c.Param("reservationUid"))
+	err = h.call("getReservationsByUid", func() error {
+		statusCode, body, err = h.getReservationsByUid(cmd.ReservationUid)
 		return err
 	})
 	if err != nil {
 		log.Err(err).Msg("failed to process request to reservation service")
-		if errors.Is(err, errNotOkStatusCode) {
-			return c.String(statusCode, string(body))
-		}
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return statusCode, body, &operationError{operation: "getReservationsByUid", err: err}
 	}
 
 	reservation := reservationResp{}
 	err = json.Unmarshal(body, &reservation)
 	if err != nil {
 		log.Err(err).Msg("failed to process request to reservation service")
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return 0, nil, err
 	}
 
 	type req struct {
@@ -824,142 +1096,248 @@ func (h *handler) ReturnBookByUser(c echo.Context) error {
 		Date      string `json:"date"`
 	}
 
-	reqBody, err := io.ReadAll(c.Request().Body)
+	reqData := req{}
+	err = json.Unmarshal(cmd.Body, &reqData)
 	if err != nil {
 		log.Err(err).Msg("failed to parse request")
-		return c.JSON(http.StatusBadRequest, echo.Map{"message": "failed to parse request"})
+		return 0, nil, fmt.Errorf("%w: %w", errInvalidRequest, err)
 	}
 
-	reqData := req{}
-	err = json.Unmarshal(reqBody, &reqData)
-	if err != nil {
-		log.Err(err).Msg("failed to parse request")
-		return c.JSON(http.StatusBadRequest, echo.Map{"message": "failed to parse request"})
+	if _, ok := conditionMap[reqData.Condition]; !ok {
+		return 0, nil, fmt.Errorf("%w: condition %q is not valid", errInvalidRequest, reqData.Condition)
 	}
 
 	starsDiff := 0
+	var reasons []string
 	targetStatus := returnedStatus
 	tillDate, err := my_time.NewDate(reservation.TillDate)
 	if err != nil {
 		log.Err(err).Msg("failed to parse till date")
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return 0, nil, err
 	}
 	reqDate, err := my_time.NewDate(reqData.Date)
 	if err != nil {
 		log.Err(err).Msg("failed to parse date")
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		return 0, nil, err
 	}
 	if time.Time(*reqDate).After(time.Time(*tillDate)) {
 		targetStatus = expiredStatus
-		starsDiff -= 10
+		starsDiff -= latePenalty
+		reasons = append(reasons, expiredStatus)
 	}
 
+	// без выданного экземпляра (недоступен library service или книга выдана до учета экземпляров)
+	// штраф за состояние не начисляется и состояние не обновляется.
+	// Ошибку поглощает fallback withoutDetails, поэтому результат call не проверяется
+	var bookCopy copyResp
+	_ = h.call("getReservedCopy", func() error {
+		var err error
+		bookCopy, err = h.getReservedCopy(reservation.ReservationUid)
+		return err
+	})
+	if worse, err := compareConditions(reqData.Condition, bookCopy.Condition); err != nil {
+		log.Warn().Err(err).Str("bookUid", reservation.BookUid).Msg("book condition is unknown, condition penalty is skipped")
+	} else if worse < 0 {
+		starsDiff -= conditionPenalty
+		reasons = append(reasons, conditionWorsenedReason)
+	}
+
+	reason := strings.Join(reasons, ",")
 	if starsDiff == 0 {
-		starsDiff = 1
+		starsDiff = returnBonus
+		reason = returnedStatus
 	}
 
-	statusCode, body, err = h.updateReservationStatus(reservation.ReservationUid, targetStatus, c.Request().Header.Get("X-User-Name"))
+	state := &returnBookState{
+		ReservationUid: reservation.ReservationUid,
+		UserName:       cmd.UserName,
+		LibraryUid:     reservation.LibraryUid,
+		BookUid:        reservation.BookUid,
+		TargetStatus:   targetStatus,
+		Condition:      reqData.Condition,
+		CopyUid:        bookCopy.CopyUid,
+		PrevCondition:  bookCopy.Condition,
+		StarsDiff:      starsDiff,
+		Reason:         reason,
+	}
+	err = h.sagas.Execute(returnBookSaga, state)
+	if errors.Is(err, saga.ErrStepsDeferred) {
+		log.Warn().Err(err).Str("reservationUid", state.ReservationUid).Msg("book returned, remaining steps deferred")
+		return http.StatusOK, nil, nil
+	}
 	if err != nil {
-		log.Err(err).Msg("failed to process request to reservation service")
-		if errors.Is(err, errNotOkStatusCode) {
-			return c.String(statusCode, string(body))
-		}
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+		log.Err(err).Msg("failed to return book")
+		return state.statusCode, state.body, err
 	}
 
-	statusCode, body, err = h.updateAvailableCount(reservation.LibraryUid, reservation.BookUid, 1)
-	// откат + возврат в очередь
+	return http.StatusOK, nil, nil
+}
+
+func (h *handler) getRatingByUser(userName string) (int, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, h.config.RatingSystemURL+"/rating/"+userName, nil)
 	if err != nil {
-		log.Err(err).Msg("failed to process request to library service")
-		statusCode, body, err = h.updateReservationStatus(reservation.ReservationUid, rentedStatus, c.Request().Header.Get("X-User-Name"))
-		if err != nil {
-			log.Err(err).Msg("failed to process request to reservation service")
-			if errors.Is(err, errNotOkStatusCode) {
-				return c.String(statusCode, string(body))
-			}
-			return c.NoContent(http.StatusNoContent)
-		}
-		
-		h.retryHandler.broker.Publish("request.retry", retryData{
-			Time:    time.Now(),
-			Call:    h.ReturnBookByUser,
-			Context: c,
-			ReqBody: reqBody,
-			Params:  map[string]string{"reservationUid": c.Param("reservationUid")},
-		})
-		return c.NoContent(http.StatusNoContent)
+		return 0, nil, err
 	}
 
-	statusCode, body, err = h.updateUserRating(c.Request().Header.Get("X-User-Name"), starsDiff)
-	// откат + возврат в очередь
+	resp, err := h.httpClient.Do(req)
 	if err != nil {
-		log.Err(err).Msg("failed to process request to rating service")
-		statusCode, body, err = h.updateReservationStatus(reservation.ReservationUid, rentedStatus, c.Request().Header.Get("X-User-Name"))
-		if err != nil {
-			log.Err(err).Msg("failed to process request to reservation service")
-			if errors.Is(err, errNotOkStatusCode) {
-				return c.String(statusCode, string(body))
-			}
-			return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
-		}
-		statusCode, body, err = h.updateAvailableCount(reservation.LibraryUid, reservation.BookUid, -1)
-		if err != nil {
-			log.Err(err).Msg("failed to process request to library service")
-			if errors.Is(err, errNotOkStatusCode) {
-				return c.String(statusCode, string(body))
-			}
-			return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
-		}
-		h.retryHandler.broker.Publish("request.retry", retryData{
-			Time:    time.Now(),
-			Call:    h.ReturnBookByUser,
-			Context: c,
-			ReqBody: reqBody,
-			Params:  map[string]string{"reservationUid": c.Param("reservationUid")},
-		})
+		return 0, nil, err
+	}
 
-		return c.NoContent(http.StatusNoContent)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
 
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
 	}
 
-	return c.NoContent(http.StatusNoContent)
+	return resp.StatusCode, body, nil
 }
 
-func (h *handler) getRatingByUser(userName string) (int, []byte, error) {
-	req, err := http.NewRequest(http.MethodGet, h.config.RatingSystemURL+"/rating/"+userName, nil)
+func (h *handler) GetRatingByUser(c echo.Context) error {
+	var statusCode int
+	var body []byte
+	var err error
+	err = h.call("getRatingByUser", func() error {
+		statusCode, body, err = h.getRatingByUser(c.Request().Header.Get("X-User-Name"))
+		return err
+	})
+	// ответы с ошибкой запроса (4xx) передаются клиенту как есть, 503 - только при отказе сервиса
+	if err != nil && (!errors.Is(err, errNotOkStatusCode) || errors.Is(err, errBonusServiceUnavailable)) {
+		log.Err(err).Msg("failed to process request to rating service")
+		return unavailableResponse(c, err)
+	}
+
+	c.Response().Header().Set("Content-Type", "application/json")
+	return c.String(statusCode, string(body))
+}
+
+func (h *handler) getRatingHistory(userName, page, size string) (int, []byte, error) {
+	queryParams := url.Values{}
+	queryParams.Add("page", page)
+	queryParams.Add("size", size)
+	reqURL, err := url.Parse(h.config.RatingSystemURL + "/rating/" + url.PathEscape(userName) + "/history")
 	if err != nil {
 		return 0, nil, err
 	}
 
-	resp, err := h.httpClient.Do(req)
+	reqURL.RawQuery = queryParams.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, nil, errors.Join(errNotOkStatusCode, fmt.Errorf("status code = %d", resp.StatusCode))
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return resp.StatusCode, nil, err
+		return 0, nil, err
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return resp.StatusCode, body, newStatusCodeError(resp.StatusCode)
 	}
 
 	return resp.StatusCode, body, nil
 }
 
-func (h *handler) GetRatingByUser(c echo.Context) error {
+func (h *handler) GetRatingHistory(c echo.Context) error {
+	var statusCode int
 	var body []byte
 	var err error
-	err = h.circuitBreakers["getRatingByUser"].Call(func() error {
-		_, body, err = h.getRatingByUser(c.Request().Header.Get("X-User-Name"))
+	err = h.call("getRatingHistory", func() error {
+		statusCode, body, err = h.getRatingHistory(c.Request().Header.Get("X-User-Name"), c.QueryParam("page"), c.QueryParam("size"))
 		return err
 	})
 	if err != nil {
 		log.Err(err).Msg("failed to process request to rating service")
-		return c.JSON(http.StatusServiceUnavailable, echo.Map{"message": "Bonus Service unavailable"})
+		return unavailableResponse(c, err)
 	}
 
 	c.Response().Header().Set("Content-Type", "application/json")
-	return c.String(http.StatusOK, string(body))
+	return c.String(statusCode, string(body))
+}
+
+// adminRequest передает административный запрос в сервис библиотек по тому же пути без префикса /api/v1.
+// Тело ответа не читается: его закрывает вызывающий.
+func (h *handler) adminRequest(method, path, rawQuery, contentType, authorization string, reqBody []byte) (*http.Response, error) {
+	reqURL, err := url.Parse(h.config.LibrarySystemURL + path)
+	if err != nil {
+		return nil, err
+	}
+	reqURL.RawQuery = rawQuery
+
+	req, err := http.NewRequest(method, reqURL.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		resp.Body.Close()
+		return nil, newStatusCodeError(resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// AdminProxy передает ответ сервиса библиотек клиенту по мере получения, не собирая его в памяти:
+// выгрузка каталога может быть большой
+func (h *handler) AdminProxy(c echo.Context) error {
+	reqBody, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		log.Err(err).Msg("failed to read body")
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "failed to read body",
+		})
+	}
+
+	var resp *http.Response
+	err = h.call("adminRequest", func() error {
+		resp, err = h.adminRequest(
+			c.Request().Method,
+			strings.TrimPrefix(c.Request().URL.Path, "/api/v1"),
+			c.Request().URL.RawQuery,
+			c.Request().Header.Get("Content-Type"),
+			c.Request().Header.Get("Authorization"),
+			reqBody,
+		)
+		return err
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to process request to library service")
+		return unavailableResponse(c, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return c.NoContent(resp.StatusCode)
+	}
+	// экспорт каталога может быть в CSV, поэтому тип ответа берется из ответа сервиса
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().WriteHeader(resp.StatusCode)
+
+	_, err = io.Copy(c.Response(), resp.Body)
+	if err != nil {
+		// ответ уже начат, поэтому ошибка только логируется
+		log.Err(err).Msg("failed to stream response of library service")
+	}
+	c.Response().Flush()
+	return nil
 }
@@ -2,15 +2,21 @@ package library_system
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/retry"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/saga"
 	circuit_breaker "github.com/Erlendum/rsoi-lab-03/pkg/circuit-breaker"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 type httpClientStub struct {
@@ -69,3 +75,688 @@ func Test_SendEvent(t *testing.T) {
 		})
 	}
 }
+
+func Test_IsUpstreamFailure(t *testing.T) {
+	var tests = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "transport error", err: errors.New("connection refused"), expected: true},
+		{name: "timeout", err: context.DeadlineExceeded, expected: true},
+		{name: "404 http-code", err: newStatusCodeError(http.StatusNotFound), expected: false},
+		{name: "400 http-code", err: newStatusCodeError(http.StatusBadRequest), expected: false},
+		{name: "500 http-code", err: newStatusCodeError(http.StatusInternalServerError), expected: true},
+		{name: "503 http-code", err: newStatusCodeError(http.StatusServiceUnavailable), expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, isUpstreamFailure(tt.err))
+		})
+	}
+}
+
+func Test_NotFoundDoesNotOpenCircuitBreaker(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{CircuitBreaker: config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute}}
+	h := handler{httpClient: &httpClientStub{statusCode: http.StatusNotFound}, config: cfg, circuitBreakers: map[string]circuitBreaker{
+		"getReservationsByUid": newCircuitBreaker(cfg.CircuitBreaker, "getReservationsByUid"),
+	}}
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/test", nil)
+		rw := httptest.NewRecorder()
+		c := e.NewContext(req, rw)
+		c.SetParamNames("reservationUid")
+		c.SetParamValues("test")
+
+		err := h.ReturnBookByUser(c)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, rw.Code)
+	}
+}
+
+type circuitBreakerObserverStub struct{}
+
+func (circuitBreakerObserverStub) Options(string) []circuit_breaker.Option {
+	return nil
+}
+
+func Test_NewCircuitBreakers(t *testing.T) {
+	t.Run("operation scope: breaker per operation", func(t *testing.T) {
+		cbs := newCircuitBreakers(config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute}, circuitBreakerObserverStub{})
+
+		require.Len(t, cbs, len(circuitBreakerUpstreams))
+		require.Equal(t, "getLibraries", cbs["getLibraries"].Name())
+		require.NotSame(t, cbs["getLibraries"], cbs["updateAvailableCount"])
+	})
+
+	t.Run("upstream scope: dead service detected once", func(t *testing.T) {
+		cbs := newCircuitBreakers(config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute, Scope: config.CircuitBreakerScopeUpstream}, circuitBreakerObserverStub{})
+
+		require.Len(t, cbs, len(circuitBreakerUpstreams))
+		require.Equal(t, librarySystem, cbs["getLibraries"].Name())
+		require.Equal(t, ratingSystem, cbs["updateUserRating"].Name())
+
+		_ = cbs["getLibraries"].Call(func() error { return errors.New("") })
+
+		require.ErrorIs(t, cbs["updateAvailableCount"].Call(func() error { return nil }), circuit_breaker.ErrCircuitBreakerOpen)
+		require.NoError(t, cbs["createReservation"].Call(func() error { return nil }))
+	})
+}
+
+func Test_Fallbacks(t *testing.T) {
+	require.Len(t, fallbacks, len(circuitBreakerUpstreams))
+
+	var tests = []struct {
+		name        string
+		operation   string
+		err         error
+		expectedNil bool
+		expectedErr error
+	}{
+		{name: "books details degrade", operation: "getBooksByUids", err: circuit_breaker.ErrCircuitBreakerOpen, expectedNil: true},
+		{name: "libraries details degrade", operation: "getLibrariesByUids", err: errors.New("connection refused"), expectedNil: true},
+		{name: "rating unavailable", operation: "getRatingByUser", err: circuit_breaker.ErrCircuitBreakerOpen, expectedErr: errBonusServiceUnavailable},
+		{name: "rating not found passes through", operation: "getRatingByUser", err: newStatusCodeError(http.StatusNotFound), expectedErr: errNotOkStatusCode},
+		{name: "library unavailable", operation: "getLibraries", err: newStatusCodeError(http.StatusBadGateway), expectedErr: errLibraryServiceUnavailable},
+		{name: "reservation unavailable", operation: "createReservation", err: errors.New("connection refused"), expectedErr: errReservationServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fallbacks[tt.operation](tt.err)
+
+			if tt.expectedNil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func Test_GetRatingByUserFallback(t *testing.T) {
+	e := echo.New()
+	cb := circuit_breaker.New(1, time.Minute)
+	cb.ForceOpen()
+	h := handler{httpClient: &httpClientStub{statusCode: http.StatusOK}, config: &config.Config{}, circuitBreakers: map[string]circuitBreaker{
+		"getRatingByUser": cb,
+	}}
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	rw := httptest.NewRecorder()
+	c := e.NewContext(req, rw)
+
+	err := h.GetRatingByUser(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	require.JSONEq(t, `{"message":"Bonus Service unavailable"}`, rw.Body.String())
+}
+
+func Test_GetRatingByUser(t *testing.T) {
+	tests := []struct {
+		name             string
+		client           *httpClientStub
+		expectedHTTPCode int
+		expectedBody     string
+	}{
+		{
+			name:             "http-code 200: success",
+			client:           &httpClientStub{statusCode: http.StatusOK},
+			expectedHTTPCode: http.StatusOK,
+			expectedBody:     "test",
+		},
+		{
+			name:             "http-code 404: client error is passed through",
+			client:           &httpClientStub{statusCode: http.StatusNotFound},
+			expectedHTTPCode: http.StatusNotFound,
+			expectedBody:     "test",
+		},
+		{
+			name:             "http-code 503: service responds with 5xx",
+			client:           &httpClientStub{statusCode: http.StatusInternalServerError},
+			expectedHTTPCode: http.StatusServiceUnavailable,
+			expectedBody:     `{"message":"Bonus Service unavailable"}`,
+		},
+		{
+			name:             "http-code 503: service is down",
+			client:           &httpClientStub{err: errors.New("connection refused")},
+			expectedHTTPCode: http.StatusServiceUnavailable,
+			expectedBody:     `{"message":"Bonus Service unavailable"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			h := handler{httpClient: tt.client, config: &config.Config{}, circuitBreakers: map[string]circuitBreaker{
+				"getRatingByUser": circuit_breaker.New(5, time.Minute),
+			}}
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			rw := httptest.NewRecorder()
+			c := e.NewContext(req, rw)
+
+			err := h.GetRatingByUser(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedHTTPCode, rw.Code)
+			require.Equal(t, tt.expectedBody, strings.TrimSpace(rw.Body.String()))
+		})
+	}
+}
+
+func Test_GetRatingHistory(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{RatingSystemURL: "http://rating", CircuitBreaker: config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute}}
+
+	var requested string
+	client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req.URL.String()
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`{"page":1}`))}, nil
+	})
+	h := handler{httpClient: client, config: cfg, circuitBreakers: newCircuitBreakers(cfg.CircuitBreaker, circuitBreakerObserverStub{})}
+
+	req := httptest.NewRequest(http.MethodGet, "/test?page=1&size=10", nil)
+	req.Header.Set("X-User-Name", "Test Max")
+	rw := httptest.NewRecorder()
+	c := e.NewContext(req, rw)
+
+	err := h.GetRatingHistory(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "http://rating/rating/Test%20Max/history?page=1&size=10", requested)
+	require.JSONEq(t, `{"page":1}`, rw.Body.String())
+
+	h.circuitBreakers["getRatingHistory"].ForceOpen()
+	rw = httptest.NewRecorder()
+	c = e.NewContext(req, rw)
+
+	err = h.GetRatingHistory(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	require.JSONEq(t, `{"message":"Bonus Service unavailable"}`, rw.Body.String())
+}
+
+func Test_AdminProxy(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{
+		LibrarySystemURL: "http://library/api/v1",
+		CircuitBreaker:   config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute},
+		Admin:            config.Admin{Token: "secret"},
+	}
+
+	var requested, authorization, body string
+	client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req.Method + " " + req.URL.String()
+		authorization = req.Header.Get("Authorization")
+		reqBody, _ := io.ReadAll(req.Body)
+		body = string(reqBody)
+		if req.Method == http.MethodDelete {
+			return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		}
+		if req.Method == http.MethodGet {
+			header := http.Header{}
+			header.Set("Content-Type", "text/csv; charset=utf-8")
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(bytes.NewBufferString("bookUid,name\nbook,test\n"))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusCreated, Body: io.NopCloser(bytes.NewBufferString(`{"libraryUid":"test"}`))}, nil
+	})
+	h := handler{httpClient: client, config: cfg, circuitBreakers: newCircuitBreakers(cfg.CircuitBreaker, circuitBreakerObserverStub{}), idempotency: idempotencyStub{}}
+	h.Register(e)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/libraries", bytes.NewBufferString(`{"name":"test"}`))
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, req)
+
+	require.Equal(t, http.StatusUnauthorized, rw.Code)
+	require.Empty(t, requested)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/libraries", bytes.NewBufferString(`{"name":"test"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "application/json")
+	rw = httptest.NewRecorder()
+	e.ServeHTTP(rw, req)
+
+	require.Equal(t, http.StatusCreated, rw.Code)
+	require.Equal(t, "POST http://library/api/v1/admin/libraries", requested)
+	require.Equal(t, "Bearer secret", authorization)
+	require.Equal(t, `{"name":"test"}`, body)
+	require.JSONEq(t, `{"libraryUid":"test"}`, rw.Body.String())
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/admin/libraries/lib/books/book", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rw = httptest.NewRecorder()
+	e.ServeHTTP(rw, req)
+
+	require.Equal(t, http.StatusNoContent, rw.Code)
+	require.Equal(t, "DELETE http://library/api/v1/admin/libraries/lib/books/book", requested)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/admin/catalog/export?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rw = httptest.NewRecorder()
+	e.ServeHTTP(rw, req)
+
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "text/csv; charset=utf-8", rw.Header().Get("Content-Type"))
+	require.Equal(t, "bookUid,name\nbook,test\n", rw.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/catalog/import?dryRun=true", bytes.NewBufferString("isbn,name\n"))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "text/csv")
+	rw = httptest.NewRecorder()
+	e.ServeHTTP(rw, req)
+
+	require.Equal(t, "POST http://library/api/v1/admin/catalog/import?dryRun=true", requested)
+	require.Equal(t, "isbn,name\n", body)
+
+	h.circuitBreakers["adminRequest"].ForceOpen()
+	rw = httptest.NewRecorder()
+	e.ServeHTTP(rw, req)
+
+	require.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+func Test_SearchBooks(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{LibrarySystemURL: "http://library", CircuitBreaker: config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute}}
+
+	var requested string
+	client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req.URL.String()
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`{"page":1,"items":[]}`))}, nil
+	})
+	h := handler{httpClient: client, config: cfg, circuitBreakers: newCircuitBreakers(cfg.CircuitBreaker, circuitBreakerObserverStub{})}
+
+	req := httptest.NewRequest(http.MethodGet, "/test?query=war+and+peace&city=Moscow&available=true&page=1&size=10", nil)
+	rw := httptest.NewRecorder()
+	c := e.NewContext(req, rw)
+
+	err := h.SearchBooks(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "http://library/books/search?available=true&city=Moscow&page=1&query=war+and+peace&size=10", requested)
+	require.JSONEq(t, `{"page":1,"items":[]}`, rw.Body.String())
+
+	h.circuitBreakers["searchBooks"].ForceOpen()
+	rw = httptest.NewRecorder()
+	c = e.NewContext(req, rw)
+
+	err = h.SearchBooks(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+type idempotencyStub struct{}
+
+func (idempotencyStub) Wrap(next echo.HandlerFunc) echo.HandlerFunc {
+	return next
+}
+
+type httpClientFunc func(req *http.Request) (*http.Response, error)
+
+func (f httpClientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type retryQueueStub struct {
+	commands []retry.Command
+}
+
+func (s *retryQueueStub) Publish(cmd retry.Command) error {
+	s.commands = append(s.commands, cmd)
+	return nil
+}
+
+func (s *retryQueueStub) Handle(map[string]func(cmd retry.Command) error) {}
+
+func Test_ReturnBookByUserDefersRatingUpdate(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{
+		ReservationSystemURL: "http://reservation",
+		LibrarySystemURL:     "http://library",
+		RatingSystemURL:      "http://rating",
+		CircuitBreaker:       config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute},
+	}
+
+	ratingAvailable := false
+	var calls []string
+	client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodPut {
+			calls = append(calls, req.URL.Host+" "+req.URL.RawQuery)
+		}
+		switch req.URL.Host {
+		case "rating":
+			if !ratingAvailable {
+				return nil, errors.New("connection refused")
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString("{}"))}, nil
+		case "reservation":
+			body := `{"reservationUid":"test","status":"RENTED","startDate":"2021-10-01","tillDate":"2021-10-11","bookUid":"book","libraryUid":"library"}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		case "library":
+			body := `{"copyUid":"copy","condition":"GOOD"}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		default:
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString("{}"))}, nil
+		}
+	})
+
+	sagaStorage, err := saga.NewStorage(filepath.Join(t.TempDir(), "saga.db"))
+	require.NoError(t, err)
+	defer sagaStorage.Close()
+
+	sagas := saga.NewOrchestrator(config.Saga{RecoveryInterval: time.Minute}, sagaStorage)
+	defer sagas.Stop(context.Background())
+
+	queue := &retryQueueStub{}
+	h := handler{
+		httpClient:      client,
+		config:          cfg,
+		circuitBreakers: newCircuitBreakers(cfg.CircuitBreaker, circuitBreakerObserverStub{}),
+		retryQueue:      queue,
+		sagas:           sagas,
+	}
+	h.sagas.Handle(h.sagaDefinitions())
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewBufferString(`{"condition":"GOOD","date":"2021-10-10"}`))
+	req.Header.Set("X-User-Name", "Test Max")
+	rw := httptest.NewRecorder()
+	c := e.NewContext(req, rw)
+	c.SetParamNames("reservationUid")
+	c.SetParamValues("test")
+
+	err = h.ReturnBookByUser(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, rw.Code)
+	require.Equal(t, []string{"reservation status=RETURNED", "library countDiff=1&operationId=test%3Areturn&reservationUid=test&returned=true", "rating operationId=test%3Areturn&reason=RETURNED&reservationUid=test&starsDiff=1"}, calls)
+	require.Len(t, queue.commands, 1)
+	cmd := queue.commands[0]
+	require.Equal(t, returnBookStepOperation("updateUserRating"), cmd.Operation)
+	require.Equal(t, "test", cmd.ReservationUid)
+	require.Equal(t, "Test Max", cmd.UserName)
+	require.Equal(t, ratingSystem, cmd.Upstream)
+
+	logs, err := sagaStorage.List()
+	require.NoError(t, err)
+	require.Empty(t, logs)
+
+	calls = nil
+	err = h.retryReturnBookStep(cmd)
+	require.ErrorIs(t, err, retry.ErrRetryLater)
+	require.Equal(t, ratingSystem, retry.UpstreamOf(err))
+
+	// повтор выполняет только отложенный шаг с тем же идентификатором операции
+	ratingAvailable = true
+	h.circuitBreakers["updateUserRating"].Reset()
+	err = h.retryReturnBookStep(cmd)
+	require.NoError(t, err)
+	require.Equal(t, []string{"rating operationId=test%3Areturn&reason=RETURNED&reservationUid=test&starsDiff=1"}, calls)
+}
+
+func Test_GetBooksByUserWithoutDetails(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{
+		ReservationSystemURL: "http://reservation",
+		LibrarySystemURL:     "http://library",
+		CircuitBreaker:       config.CircuitBreaker{MaxFailures: 5, ResetTimeout: time.Minute},
+	}
+	client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "library" {
+			return nil, errors.New("connection refused")
+		}
+		body := `[{"reservationUid":"test","status":"RENTED","startDate":"2021-10-01","tillDate":"2021-10-11","bookUid":"book","libraryUid":"library"}]`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+	})
+	h := handler{
+		httpClient:      client,
+		config:          cfg,
+		circuitBreakers: newCircuitBreakers(cfg.CircuitBreaker, circuitBreakerObserverStub{}),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-User-Name", "Test Max")
+	rw := httptest.NewRecorder()
+	c := e.NewContext(req, rw)
+
+	err := h.GetBooksByUser(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.JSONEq(t, `[{"reservationUid":"test","status":"RENTED","startDate":"2021-10-01","tillDate":"2021-10-11","bookUid":"book","libraryUid":"library"}]`, rw.Body.String())
+}
+
+func Test_RetryReturnBook(t *testing.T) {
+	reservation := `{"reservationUid":"test","status":"RENTED","startDate":"2021-10-01","tillDate":"2021-10-11","bookUid":"book","libraryUid":"library"}`
+
+	tests := []struct {
+		name             string
+		body             string
+		reservation      func(req *http.Request) (*http.Response, error)
+		expectedUpstream string
+	}{
+		{
+			name: "reservation service is unavailable",
+			body: `{"condition":"GOOD","date":"2021-10-10"}`,
+			reservation: func(req *http.Request) (*http.Response, error) {
+				return nil, errors.New("connection refused")
+			},
+			expectedUpstream: reservationSystem,
+		},
+		{
+			name: "status update responds with 5xx",
+			body: `{"condition":"GOOD","date":"2021-10-10"}`,
+			reservation: func(req *http.Request) (*http.Response, error) {
+				if req.Method != http.MethodGet {
+					return &http.Response{StatusCode: http.StatusInternalServerError, Body: io.NopCloser(bytes.NewBufferString("{}"))}, nil
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(reservation))}, nil
+			},
+			expectedUpstream: reservationSystem,
+		},
+		{
+			name: "reservation not found is not retried",
+			body: `{"condition":"GOOD","date":"2021-10-10"}`,
+			reservation: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString("{}"))}, nil
+			},
+		},
+		{
+			name: "invalid request is not retried",
+			body: `{"condition":"test","date":"2021-10-10"}`,
+			reservation: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(reservation))}, nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				ReservationSystemURL: "http://reservation",
+				LibrarySystemURL:     "http://library",
+				CircuitBreaker:       config.CircuitBreaker{MaxFailures: 5, ResetTimeout: time.Minute},
+			}
+			client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+				if req.URL.Host == "reservation" {
+					return tt.reservation(req)
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`{"copyUid":"copy","condition":"GOOD"}`))}, nil
+			})
+
+			sagaStorage, err := saga.NewStorage(filepath.Join(t.TempDir(), "saga.db"))
+			require.NoError(t, err)
+			defer sagaStorage.Close()
+
+			sagas := saga.NewOrchestrator(config.Saga{RecoveryInterval: time.Minute}, sagaStorage)
+			defer sagas.Stop(context.Background())
+
+			h := handler{
+				httpClient:      client,
+				config:          cfg,
+				circuitBreakers: newCircuitBreakers(cfg.CircuitBreaker, circuitBreakerObserverStub{}),
+				sagas:           sagas,
+			}
+			h.sagas.Handle(h.sagaDefinitions())
+
+			err = h.retryReturnBook(retry.Command{Operation: returnBookOperation, ReservationUid: "test", UserName: "Test Max", Body: []byte(tt.body)})
+
+			require.Error(t, err)
+			if tt.expectedUpstream == "" {
+				require.NotErrorIs(t, err, retry.ErrRetryLater)
+				return
+			}
+			require.ErrorIs(t, err, retry.ErrRetryLater)
+			require.Equal(t, tt.expectedUpstream, retry.UpstreamOf(err))
+		})
+	}
+}
+
+func Test_ReturnBookByUserPenalizesConditionAndLateness(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{
+		ReservationSystemURL: "http://reservation",
+		LibrarySystemURL:     "http://library",
+		RatingSystemURL:      "http://rating",
+		CircuitBreaker:       config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute},
+	}
+
+	var tests = []struct {
+		name          string
+		condition     string
+		body          string
+		expectedCalls []string
+	}{
+		{
+			name:      "same condition in time",
+			condition: "EXCELLENT",
+			body:      `{"condition":"EXCELLENT","date":"2021-10-10"}`,
+			expectedCalls: []string{
+				"/reservations/test/status status=RETURNED",
+				"/libraries/library/books/book countDiff=1&operationId=test%3Areturn&reservationUid=test&returned=true",
+				"/rating/Test Max operationId=test%3Areturn&reason=RETURNED&reservationUid=test&starsDiff=1",
+			},
+		},
+		{
+			name:      "better condition is not rewarded",
+			condition: "GOOD",
+			body:      `{"condition":"EXCELLENT","date":"2021-10-10"}`,
+			expectedCalls: []string{
+				"/reservations/test/status status=RETURNED",
+				"/libraries/library/books/book countDiff=1&operationId=test%3Areturn&reservationUid=test&returned=true",
+				"/copies/copy/condition condition=EXCELLENT",
+				"/rating/Test Max operationId=test%3Areturn&reason=RETURNED&reservationUid=test&starsDiff=1",
+			},
+		},
+		{
+			name:      "worse condition",
+			condition: "EXCELLENT",
+			body:      `{"condition":"BAD","date":"2021-10-10"}`,
+			expectedCalls: []string{
+				"/reservations/test/status status=RETURNED",
+				"/libraries/library/books/book countDiff=1&operationId=test%3Areturn&reservationUid=test&returned=true",
+				"/copies/copy/condition condition=BAD",
+				"/rating/Test Max operationId=test%3Areturn&reason=CONDITION_WORSENED&reservationUid=test&starsDiff=-10",
+			},
+		},
+		{
+			name: "copy issued before per-copy tracking",
+			body: `{"condition":"BAD","date":"2021-10-10"}`,
+			expectedCalls: []string{
+				"/reservations/test/status status=RETURNED",
+				"/libraries/library/books/book countDiff=1&operationId=test%3Areturn&reservationUid=test&returned=true",
+				"/rating/Test Max operationId=test%3Areturn&reason=RETURNED&reservationUid=test&starsDiff=1",
+			},
+		},
+		{
+			name:      "worse condition and late",
+			condition: "EXCELLENT",
+			body:      `{"condition":"GOOD","date":"2021-10-12"}`,
+			expectedCalls: []string{
+				"/reservations/test/status status=EXPIRED",
+				"/libraries/library/books/book countDiff=1&operationId=test%3Areturn&reservationUid=test&returned=true",
+				"/copies/copy/condition condition=GOOD",
+				"/rating/Test Max operationId=test%3Areturn&reason=EXPIRED%2CCONDITION_WORSENED&reservationUid=test&starsDiff=-20",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+				body := "{}"
+				switch {
+				case req.Method == http.MethodPut:
+					calls = append(calls, req.URL.Path+" "+req.URL.RawQuery)
+				case req.URL.Host == "reservation":
+					body = `{"reservationUid":"test","status":"RENTED","startDate":"2021-10-01","tillDate":"2021-10-11","bookUid":"book","libraryUid":"library"}`
+				case req.URL.Host == "library" && tt.condition == "":
+					return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+				case req.URL.Host == "library":
+					body = `{"copyUid":"copy","condition":"` + tt.condition + `"}`
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+			})
+
+			sagaStorage, err := saga.NewStorage(filepath.Join(t.TempDir(), "saga.db"))
+			require.NoError(t, err)
+			defer sagaStorage.Close()
+
+			sagas := saga.NewOrchestrator(config.Saga{RecoveryInterval: time.Minute}, sagaStorage)
+			defer sagas.Stop(context.Background())
+
+			h := handler{
+				httpClient:      client,
+				config:          cfg,
+				circuitBreakers: newCircuitBreakers(cfg.CircuitBreaker, circuitBreakerObserverStub{}),
+				retryQueue:      &retryQueueStub{},
+				sagas:           sagas,
+			}
+			h.sagas.Handle(h.sagaDefinitions())
+
+			req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewBufferString(tt.body))
+			req.Header.Set("X-User-Name", "Test Max")
+			rw := httptest.NewRecorder()
+			c := e.NewContext(req, rw)
+			c.SetParamNames("reservationUid")
+			c.SetParamValues("test")
+
+			err = h.ReturnBookByUser(c)
+
+			require.NoError(t, err)
+			require.Equal(t, http.StatusNoContent, rw.Code)
+			require.Equal(t, tt.expectedCalls, calls)
+		})
+	}
+}
+
+func Test_ReturnBookByUserRejectsUnknownCondition(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{ReservationSystemURL: "http://reservation", CircuitBreaker: config.CircuitBreaker{MaxFailures: 1, ResetTimeout: time.Minute}}
+	client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"reservationUid":"test","status":"RENTED","startDate":"2021-10-01","tillDate":"2021-10-11","bookUid":"book","libraryUid":"library"}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+	})
+	h := handler{httpClient: client, config: cfg, circuitBreakers: newCircuitBreakers(cfg.CircuitBreaker, circuitBreakerObserverStub{})}
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewBufferString(`{"condition":"NEW","date":"2021-10-10"}`))
+	rw := httptest.NewRecorder()
+	c := e.NewContext(req, rw)
+	c.SetParamNames("reservationUid")
+	c.SetParamValues("test")
+
+	err := h.ReturnBookByUser(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rw.Code)
+}
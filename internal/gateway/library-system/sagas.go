@@ -0,0 +1,223 @@
+package library_system
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/retry"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/saga"
+	"net/http"
+	"strings"
+)
+
+const (
+	reserveBookSaga = "reserveBook"
+	returnBookSaga  = "returnBook"
+)
+
+func (h *handler) sagaDefinitions() map[string]saga.Definition {
+	return map[string]saga.Definition{
+		reserveBookSaga: {
+			NewState: func() any { return &reserveBookState{} },
+			Steps:    func(state any) []saga.Step { return h.reserveBookSteps(state.(*reserveBookState)) },
+		},
+		returnBookSaga: {
+			NewState: func() any { return &returnBookState{} },
+			Steps:    func(state any) []saga.Step { return h.returnBookSteps(state.(*returnBookState)) },
+			Defer:    func(state any, steps []string) error { return h.deferReturnBookSteps(state.(*returnBookState), steps) },
+		},
+	}
+}
+
+// reserveBookState - данные саги бронирования. Ответ сервиса на последний шаг (statusCode, body)
+// нужен только обработчику запроса и в журнал не попадает.
+type reserveBookState struct {
+	UserName    string          `json:"userName"`
+	ReqBody     []byte          `json:"reqBody"`
+	Reservation reservationResp `json:"reservation"`
+	statusCode  int
+	body        []byte
+}
+
+func (h *handler) reserveBookSteps(s *reserveBookState) []saga.Step {
+	return []saga.Step{
+		{
+			Name: "createReservation",
+			Action: func() error {
+				err := h.call("createReservation", func() error {
+					var err error
+					s.statusCode, s.body, err = h.createReservation(s.ReqBody, s.UserName)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return json.Unmarshal(s.body, &s.Reservation)
+			},
+			Compensate: func() error {
+				// без uid бронь не создана: сервис отклонил запрос или не ответил до ее создания
+				if s.Reservation.ReservationUid == "" {
+					return nil
+				}
+				return h.call("deleteReservation", func() error {
+					_, err := h.deleteReservation(s.Reservation.ReservationUid)
+					return err
+				})
+			},
+		},
+		{
+			Name: "updateAvailableCount",
+			Action: func() error {
+				return h.call("updateAvailableCount", func() error {
+					var err error
+					s.statusCode, s.body, err = h.updateAvailableCount(s.Reservation.LibraryUid, s.Reservation.BookUid, -1, s.Reservation.ReservationUid, operationId(s.Reservation.ReservationUid, "reserve"), false)
+					return err
+				})
+			},
+			Compensate: func() error {
+				return h.call("updateAvailableCount", func() error {
+					_, _, err := h.updateAvailableCount(s.Reservation.LibraryUid, s.Reservation.BookUid, 1, s.Reservation.ReservationUid, operationId(s.Reservation.ReservationUid, "cancel"), false)
+					return err
+				})
+			},
+		},
+	}
+}
+
+// returnBookState - данные саги возврата книги. Точка невозврата - смена статуса брони:
+// после нее счетчик книг, состояние книги и рейтинг обновляются независимо, а неудавшиеся
+// обновления повторяются из очереди. CopyUid и PrevCondition - выданный экземпляр и его состояние до возврата,
+// пустые, если экземпляр неизвестен.
+type returnBookState struct {
+	ReservationUid string `json:"reservationUid"`
+	UserName       string `json:"userName"`
+	LibraryUid     string `json:"libraryUid"`
+	BookUid        string `json:"bookUid"`
+	TargetStatus   string `json:"targetStatus"`
+	Condition      string `json:"condition"`
+	CopyUid        string `json:"copyUid"`
+	PrevCondition  string `json:"prevCondition"`
+	StarsDiff      int    `json:"starsDiff"`
+	Reason         string `json:"reason"`
+	statusCode     int
+	body           []byte
+}
+
+func (h *handler) returnBookSteps(s *returnBookState) []saga.Step {
+	return []saga.Step{
+		{
+			Name: "updateReservationStatus",
+			Action: func() error {
+				return h.call("updateReservationStatus", func() error {
+					var err error
+					s.statusCode, s.body, err = h.updateReservationStatus(s.ReservationUid, s.TargetStatus, s.UserName)
+					return err
+				})
+			},
+			Compensate: func() error {
+				// сервис отклонил смену статуса, возвращать прежний нечего
+				if s.statusCode >= http.StatusBadRequest && s.statusCode < http.StatusInternalServerError {
+					return nil
+				}
+				return h.call("updateReservationStatus", func() error {
+					_, _, err := h.updateReservationStatus(s.ReservationUid, rentedStatus, s.UserName)
+					return err
+				})
+			},
+		},
+		{
+			Name: "updateAvailableCount",
+			Action: func() error {
+				return h.call("updateAvailableCount", func() error {
+					_, _, err := h.updateAvailableCount(s.LibraryUid, s.BookUid, 1, s.ReservationUid, operationId(s.ReservationUid, "return"), true)
+					return err
+				})
+			},
+			Retriable: true,
+		},
+		{
+			Name: "updateBookCondition",
+			Action: func() error {
+				if s.CopyUid == "" || s.Condition == s.PrevCondition {
+					return nil
+				}
+				return h.call("updateBookCondition", func() error {
+					_, _, err := h.updateBookCondition(s.CopyUid, s.Condition)
+					return err
+				})
+			},
+			Retriable: true,
+		},
+		{
+			Name: "updateUserRating",
+			Action: func() error {
+				return h.call("updateUserRating", func() error {
+					_, _, err := h.updateUserRating(s.UserName, s.StarsDiff, s.Reason, s.ReservationUid, operationId(s.ReservationUid, "return"))
+					return err
+				})
+			},
+			Retriable: true,
+		},
+	}
+}
+
+// returnBookStepOperation - операция очереди повторов для шага step саги возврата
+func returnBookStepOperation(step string) string {
+	return returnBookSaga + "." + step
+}
+
+// deferReturnBookSteps ставит в очередь повторов по команде на каждый невыполненный шаг возврата.
+// Команда содержит данные саги, поэтому повтор не перечитывает бронь и не пересчитывает рейтинг.
+func (h *handler) deferReturnBookSteps(s *returnBookState, steps []string) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		err = h.retryQueue.Publish(retry.Command{
+			Operation:      returnBookStepOperation(step),
+			ReservationUid: s.ReservationUid,
+			UserName:       s.UserName,
+			Body:           body,
+			Upstream:       circuitBreakerUpstreams[step],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returnBookStepExecutors - обработчики очереди повторов для шагов возврата после точки невозврата
+func (h *handler) returnBookStepExecutors() map[string]func(cmd retry.Command) error {
+	res := map[string]func(cmd retry.Command) error{}
+	for _, step := range h.returnBookSteps(&returnBookState{}) {
+		if step.Retriable {
+			res[returnBookStepOperation(step.Name)] = h.retryReturnBookStep
+		}
+	}
+	return res
+}
+
+// retryReturnBookStep повторяет один шаг возврата по данным саги из команды
+func (h *handler) retryReturnBookStep(cmd retry.Command) error {
+	state := &returnBookState{}
+	err := json.Unmarshal(cmd.Body, state)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimPrefix(cmd.Operation, returnBookSaga+".")
+	for _, step := range h.returnBookSteps(state) {
+		if step.Name != name {
+			continue
+		}
+
+		err = step.Action()
+		if err != nil && isUpstreamFailure(err) {
+			return retry.Later(circuitBreakerUpstreams[name], err)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown return book step: %s", name)
+}
@@ -2,9 +2,16 @@ package manager
 
 import (
 	"context"
+	circuit_breakers "github.com/Erlendum/rsoi-lab-03/internal/gateway/circuit-breakers"
 	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
 	"github.com/Erlendum/rsoi-lab-03/internal/gateway/http"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/idempotency"
 	library_system "github.com/Erlendum/rsoi-lab-03/internal/gateway/library-system"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/metrics"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/retry"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/saga"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"os"
@@ -16,10 +23,24 @@ type server interface {
 	Stop(ctx context.Context) error
 }
 
+type storage interface {
+	Close() error
+}
+
+type worker interface {
+	Stop(ctx context.Context) error
+}
+
 type root struct {
-	errorChan chan error
-	server    server
-	cfg       *config.Config
+	errorChan          chan error
+	server             server
+	cfg                *config.Config
+	retryStorage       storage
+	retryQueue         worker
+	sagaStorage        storage
+	sagas              worker
+	idempotencyStorage storage
+	idempotency        worker
 }
 
 func NewRoot() *root {
@@ -37,9 +58,49 @@ func (r *root) Register(ctx context.Context) error {
 		return err
 	}
 
-	librarySystemHandler := library_system.NewHandler(r.cfg)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	circuitBreakerCollector := metrics.NewCircuitBreakerCollector(registry)
+
+	retryStorage, err := retry.NewStorage(r.cfg.RetryQueue.Path)
+	if err != nil {
+		log.Error().Err(err).Msg("retry storage init error")
+		return err
+	}
+	r.retryStorage = retryStorage
+	retryQueue := retry.NewQueue(r.cfg.RetryQueue, retryStorage)
+	r.retryQueue = retryQueue
+
+	sagaStorage, err := saga.NewStorage(r.cfg.Saga.Path)
+	if err != nil {
+		log.Error().Err(err).Msg("saga storage init error")
+		return err
+	}
+	r.sagaStorage = sagaStorage
+	sagas := saga.NewOrchestrator(r.cfg.Saga, sagaStorage)
+	r.sagas = sagas
+
+	idempotencyStorage, err := idempotency.NewStorage(r.cfg.Idempotency.Path)
+	if err != nil {
+		log.Error().Err(err).Msg("idempotency storage init error")
+		return err
+	}
+	r.idempotencyStorage = idempotencyStorage
+	idempotencyMiddleware := idempotency.NewMiddleware(r.cfg.Idempotency, idempotencyStorage)
+	r.idempotency = idempotencyMiddleware
+
+	librarySystemHandler := library_system.NewHandler(r.cfg, circuitBreakerCollector, retryQueue, sagas, idempotencyMiddleware)
+	metricsHandler := metrics.NewHandler(registry)
+
+	circuitBreakersHandler := circuit_breakers.NewHandler(r.cfg.Admin)
+	for _, cb := range librarySystemHandler.CircuitBreakers() {
+		circuitBreakersHandler.Add(cb)
+	}
+
+	retryHandler := retry.NewHandler(retryQueue, r.cfg.Admin)
 
-	r.server = http.NewServer(&r.cfg.Server, librarySystemHandler)
+	r.server = http.NewServer(&r.cfg.Server, librarySystemHandler, metricsHandler, circuitBreakersHandler, retryHandler)
 
 	err = r.server.Init()
 	if err != nil {
@@ -71,4 +132,27 @@ func (r *root) Release(ctx context.Context, signal os.Signal) {
 	if err := r.server.Stop(ctx); err != nil {
 		log.Err(err).Msg("could not stop server")
 	}
+	// восстановление саг останавливается первым: оно передает шаги в очередь повторов.
+	// Выполняемые повторы дорабатывают до закрытия хранилищ, остальные останутся в очереди до перезапуска.
+	// Повторы возврата выполняют сагу, поэтому хранилище саг закрывается после остановки очереди
+	drainCtx, cancel := context.WithTimeout(ctx, r.cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := r.sagas.Stop(drainCtx); err != nil {
+		log.Err(err).Msg("could not stop saga recovery")
+	}
+	if err := r.retryQueue.Stop(drainCtx); err != nil {
+		log.Err(err).Msg("could not drain retry queue")
+	}
+	if err := r.sagaStorage.Close(); err != nil {
+		log.Err(err).Msg("could not close saga storage")
+	}
+	if err := r.retryStorage.Close(); err != nil {
+		log.Err(err).Msg("could not close retry storage")
+	}
+	if err := r.idempotency.Stop(drainCtx); err != nil {
+		log.Err(err).Msg("could not stop idempotency keys cleanup")
+	}
+	if err := r.idempotencyStorage.Close(); err != nil {
+		log.Err(err).Msg("could not close idempotency storage")
+	}
 }
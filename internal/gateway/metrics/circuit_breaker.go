@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	circuit_breaker "github.com/Erlendum/rsoi-lab-03/pkg/circuit-breaker"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const (
+	namespace = "gateway"
+	subsystem = "circuit_breaker"
+)
+
+// значения метрики состояния breaker'а
+var stateValues = map[circuit_breaker.State]float64{
+	circuit_breaker.StateClosed:   0,
+	circuit_breaker.StateHalfOpen: 1,
+	circuit_breaker.StateOpen:     2,
+}
+
+type circuitBreakerCollector struct {
+	state       *prometheus.GaugeVec
+	calls       *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	transitions *prometheus.CounterVec
+}
+
+func NewCircuitBreakerCollector(registerer prometheus.Registerer) *circuitBreakerCollector {
+	c := &circuitBreakerCollector{
+		state: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "state",
+			Help:      "Current circuit breaker state: 0 - closed, 1 - half-open, 2 - open.",
+		}, []string{"breaker"}),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "calls_total",
+			Help:      "Calls through circuit breaker by outcome: success, ignored, failure or rejected.",
+		}, []string{"breaker", "outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "call_duration_seconds",
+			Help:      "Latency of calls executed through circuit breaker.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"breaker", "outcome"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "transitions_total",
+			Help:      "Circuit breaker state transitions.",
+		}, []string{"breaker", "from", "to"}),
+	}
+
+	registerer.MustRegister(c.state, c.calls, c.duration, c.transitions)
+
+	return c
+}
+
+// Options возвращает опции, подключающие breaker с именем name к метрикам.
+func (c *circuitBreakerCollector) Options(name string) []circuit_breaker.Option {
+	c.state.WithLabelValues(name).Set(stateValues[circuit_breaker.StateClosed])
+	return []circuit_breaker.Option{
+		circuit_breaker.WithOnStateChange(c.onStateChange),
+		circuit_breaker.WithOnCall(c.onCall),
+	}
+}
+
+func (c *circuitBreakerCollector) onStateChange(name string, from, to circuit_breaker.State) {
+	c.state.WithLabelValues(name).Set(stateValues[to])
+	c.transitions.WithLabelValues(name, string(from), string(to)).Inc()
+}
+
+func (c *circuitBreakerCollector) onCall(name string, outcome circuit_breaker.Outcome, latency time.Duration) {
+	c.calls.WithLabelValues(name, string(outcome)).Inc()
+	if outcome != circuit_breaker.OutcomeRejected {
+		c.duration.WithLabelValues(name, string(outcome)).Observe(latency.Seconds())
+	}
+}
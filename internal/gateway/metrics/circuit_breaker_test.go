@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"errors"
+	circuit_breaker "github.com/Erlendum/rsoi-lab-03/pkg/circuit-breaker"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CircuitBreakerCollector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	collector := NewCircuitBreakerCollector(registry)
+
+	cb := circuit_breaker.New(1, time.Minute, append(collector.Options("test"), circuit_breaker.WithName("test"))...)
+
+	require.Equal(t, float64(0), testutil.ToFloat64(collector.state.WithLabelValues("test")))
+
+	_ = cb.Call(func() error { return nil })
+	_ = cb.Call(func() error { return errors.New("") })
+	_ = cb.Call(func() error { return nil })
+
+	require.Equal(t, float64(2), testutil.ToFloat64(collector.state.WithLabelValues("test")))
+	require.Equal(t, float64(1), testutil.ToFloat64(collector.calls.WithLabelValues("test", "success")))
+	require.Equal(t, float64(1), testutil.ToFloat64(collector.calls.WithLabelValues("test", "failure")))
+	require.Equal(t, float64(1), testutil.ToFloat64(collector.calls.WithLabelValues("test", "rejected")))
+	require.Equal(t, float64(1), testutil.ToFloat64(collector.transitions.WithLabelValues("test", "closed", "open")))
+}
+
+func Test_GetMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	collector := NewCircuitBreakerCollector(registry)
+	collector.Options("test")
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/manage/metrics", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	err := NewHandler(registry).GetMetrics(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, strings.Contains(rec.Body.String(), `gateway_circuit_breaker_state{breaker="test"} 0`))
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+type handler struct {
+	metricsHandler http.Handler
+}
+
+func NewHandler(gatherer prometheus.Gatherer) *handler {
+	return &handler{metricsHandler: promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})}
+}
+
+func (h *handler) Register(echo *echo.Echo) {
+	echo.GET("/manage/metrics", h.GetMetrics)
+}
+
+func (h *handler) GetMetrics(c echo.Context) error {
+	h.metricsHandler.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
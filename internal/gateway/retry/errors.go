@@ -0,0 +1,47 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrRetryLater - операция не выполнена из-за временного отказа, ее изменения откатаны и ее нужно повторить
+	ErrRetryLater = errors.New("operation must be retried later")
+
+	errUnknownOperation  = errors.New("unknown operation")
+	errCommandNotFound   = errors.New("retry command not found")
+	errCommandInProgress = errors.New("retry command is being executed")
+)
+
+// laterError - ErrRetryLater с сервисом, из-за отказа которого операция отложена
+type laterError struct {
+	upstream string
+	err      error
+}
+
+// Later сообщает, что операцию нужно повторить, когда сервис upstream восстановится
+func Later(upstream string, err error) error {
+	return &laterError{upstream: upstream, err: err}
+}
+
+func (e *laterError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrRetryLater, e.err)
+}
+
+func (e *laterError) Is(target error) bool {
+	return target == ErrRetryLater
+}
+
+func (e *laterError) Unwrap() error {
+	return e.err
+}
+
+// UpstreamOf возвращает сервис, из-за которого отложена операция, или пустую строку
+func UpstreamOf(err error) string {
+	var laterErr *laterError
+	if errors.As(err, &laterErr) {
+		return laterErr.upstream
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package retry
+
+import (
+	"errors"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/Erlendum/rsoi-lab-03/pkg/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type retryQueue interface {
+	Pending() ([]Command, error)
+	Dead() ([]Command, error)
+	Replay(id uint64) (Command, error)
+	Discard(id uint64) error
+}
+
+type handler struct {
+	queue retryQueue
+	cfg   config.Admin
+}
+
+func NewHandler(queue retryQueue, cfg config.Admin) *handler {
+	return &handler{queue: queue, cfg: cfg}
+}
+
+func (h *handler) Register(echo *echo.Echo) {
+	// повтор и удаление команд меняют данные, поэтому очередь доступна только с токеном
+	api := echo.Group("/manage/retries", auth.AdminToken(h.cfg.Token))
+
+	api.GET("", h.GetRetries)
+	api.GET("/dead", h.GetDeadRetries)
+	api.POST("/:id/replay", h.ReplayRetry)
+	api.DELETE("/:id", h.DiscardRetry)
+}
+
+type commandResp struct {
+	ID             uint64     `json:"id"`
+	Operation      string     `json:"operation"`
+	ReservationUid string     `json:"reservationUid"`
+	UserName       string     `json:"userName"`
+	Upstream       string     `json:"upstream"`
+	Attempts       int        `json:"attempts"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt"`
+	LastError      string     `json:"lastError"`
+}
+
+func newCommandResp(cmd Command) commandResp {
+	res := commandResp{
+		ID:             cmd.ID,
+		Operation:      cmd.Operation,
+		ReservationUid: cmd.ReservationUid,
+		UserName:       cmd.UserName,
+		Upstream:       cmd.Upstream,
+		Attempts:       cmd.Attempt,
+		CreatedAt:      cmd.CreatedAt,
+		LastError:      cmd.LastError,
+	}
+	if !cmd.NextAttemptAt.IsZero() {
+		res.NextAttemptAt = &cmd.NextAttemptAt
+	}
+	return res
+}
+
+func newCommandsResp(commands []Command) []commandResp {
+	res := make([]commandResp, 0, len(commands))
+	for _, cmd := range commands {
+		res = append(res, newCommandResp(cmd))
+	}
+	return res
+}
+
+func (h *handler) GetRetries(c echo.Context) error {
+	pending, err := h.queue.Pending()
+	if err != nil {
+		log.Err(err).Msg("failed to list pending retries")
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+	}
+
+	dead, err := h.queue.Dead()
+	if err != nil {
+		log.Err(err).Msg("failed to list dead retries")
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+	}
+
+	type response struct {
+		Pending []commandResp `json:"pending"`
+		Dead    []commandResp `json:"dead"`
+	}
+
+	return c.JSON(http.StatusOK, response{
+		Pending: newCommandsResp(pending),
+		Dead:    newCommandsResp(dead),
+	})
+}
+
+func (h *handler) GetDeadRetries(c echo.Context) error {
+	commands, err := h.queue.Dead()
+	if err != nil {
+		log.Err(err).Msg("failed to list dead retries")
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+	}
+
+	return c.JSON(http.StatusOK, newCommandsResp(commands))
+}
+
+func (h *handler) ReplayRetry(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid retry id"})
+	}
+
+	cmd, err := h.queue.Replay(id)
+	if err != nil {
+		return h.errorResponse(c, err)
+	}
+	log.Warn().Uint64("id", id).Msg("retry replayed manually")
+
+	return c.JSON(http.StatusOK, newCommandResp(cmd))
+}
+
+func (h *handler) DiscardRetry(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid retry id"})
+	}
+
+	err = h.queue.Discard(id)
+	if err != nil {
+		return h.errorResponse(c, err)
+	}
+	log.Warn().Uint64("id", id).Msg("retry discarded manually")
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) errorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errCommandNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"message": errCommandNotFound.Error()})
+	case errors.Is(err, errCommandInProgress):
+		return c.JSON(http.StatusConflict, echo.Map{"message": errCommandInProgress.Error()})
+	default:
+		log.Err(err).Msg("failed to process retry")
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process request"})
+	}
+}
@@ -0,0 +1,173 @@
+package retry
+
+import (
+	"encoding/json"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_GetDeadRetries(t *testing.T) {
+	storage := newTestStorage(t)
+	cmd := Command{Operation: "returnBook", ReservationUid: "test", UserName: "Test Max", Attempt: 3, LastError: "connection refused"}
+	require.NoError(t, storage.Put(&cmd))
+	require.NoError(t, storage.Bury(cmd))
+
+	h := NewHandler(NewQueue(config.RetryQueue{}, storage), config.Admin{})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/manage/retries/dead", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	err := h.GetDeadRetries(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var items []commandResp
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &items))
+	require.Len(t, items, 1)
+	require.Equal(t, cmd.ID, items[0].ID)
+	require.Equal(t, "test", items[0].ReservationUid)
+	require.Equal(t, "Test Max", items[0].UserName)
+	require.Equal(t, 3, items[0].Attempts)
+	require.Equal(t, "connection refused", items[0].LastError)
+	require.Nil(t, items[0].NextAttemptAt)
+}
+
+func newTestContext(method, path string, names, values []string) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	req := httptest.NewRequest(method, path, nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	return c, rec
+}
+
+func Test_GetRetries(t *testing.T) {
+	storage := newTestStorage(t)
+	q := NewQueue(config.RetryQueue{InitialDelay: time.Hour}, storage)
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "pending"}))
+	dead := Command{Operation: "returnBook", ReservationUid: "dead"}
+	require.NoError(t, storage.Put(&dead))
+	require.NoError(t, storage.Bury(dead))
+
+	c, rec := newTestContext(http.MethodGet, "/manage/retries", nil, nil)
+
+	err := NewHandler(q, config.Admin{}).GetRetries(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Pending []commandResp `json:"pending"`
+		Dead    []commandResp `json:"dead"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Pending, 1)
+	require.Equal(t, "pending", resp.Pending[0].ReservationUid)
+	require.NotNil(t, resp.Pending[0].NextAttemptAt)
+	require.Len(t, resp.Dead, 1)
+	require.Equal(t, "dead", resp.Dead[0].ReservationUid)
+}
+
+func Test_ReplayRetry(t *testing.T) {
+	storage := newTestStorage(t)
+	q := NewQueue(config.RetryQueue{InitialDelay: time.Hour}, storage)
+	executed := make(chan Command, 2)
+	q.Handle(map[string]func(cmd Command) error{
+		"returnBook": func(cmd Command) error {
+			executed <- cmd
+			return nil
+		},
+	})
+
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "pending"}))
+	dead := Command{Operation: "returnBook", ReservationUid: "dead", Attempt: 5}
+	require.NoError(t, storage.Put(&dead))
+	require.NoError(t, storage.Bury(dead))
+
+	for _, id := range []string{"1", "2"} {
+		c, rec := newTestContext(http.MethodPost, "/manage/retries/"+id+"/replay", []string{"id"}, []string{id})
+
+		err := NewHandler(q, config.Admin{}).ReplayRetry(c)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		select {
+		case cmd := <-executed:
+			require.Equal(t, id, strconv.FormatUint(cmd.ID, 10))
+			require.Zero(t, cmd.Attempt)
+		case <-time.After(time.Second):
+			t.Fatal("replayed command was not executed")
+		}
+	}
+
+	requireStored(t, storage, 0, 0)
+}
+
+func Test_DiscardRetry(t *testing.T) {
+	storage := newTestStorage(t)
+	q := NewQueue(config.RetryQueue{InitialDelay: time.Hour}, storage)
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "pending"}))
+	dead := Command{Operation: "returnBook", ReservationUid: "dead"}
+	require.NoError(t, storage.Put(&dead))
+	require.NoError(t, storage.Bury(dead))
+
+	var tests = []struct {
+		name         string
+		id           string
+		expectedCode int
+	}{
+		{name: "pending", id: "1", expectedCode: http.StatusNoContent},
+		{name: "dead", id: "2", expectedCode: http.StatusNoContent},
+		{name: "already discarded", id: "1", expectedCode: http.StatusNotFound},
+		{name: "invalid id", id: "abc", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newTestContext(http.MethodDelete, "/manage/retries/"+tt.id, []string{"id"}, []string{tt.id})
+
+			err := NewHandler(q, config.Admin{}).DiscardRetry(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedCode, rec.Code)
+		})
+	}
+
+	requireStored(t, storage, 0, 0)
+}
+
+func Test_ManageRetriesRequiresAdminToken(t *testing.T) {
+	storage := newTestStorage(t)
+	cmd := Command{Operation: "returnBook", ReservationUid: "test", UserName: "Test Max"}
+	require.NoError(t, storage.Put(&cmd))
+
+	e := echo.New()
+	NewHandler(NewQueue(config.RetryQueue{}, storage), config.Admin{Token: "secret"}).Register(e)
+
+	req := httptest.NewRequest(http.MethodDelete, "/manage/retries/"+strconv.FormatUint(cmd.ID, 10), nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	pending, err := storage.List()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+
+	req = httptest.NewRequest(http.MethodGet, "/manage/retries", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,339 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/rs/zerolog/log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Command - отложенная операция. Хранится на диске и содержит все, что нужно для ее выполнения,
+// поэтому не зависит от HTTP-запроса, в котором была принята.
+type Command struct {
+	ID             uint64    `json:"id"`
+	Operation      string    `json:"operation"`
+	ReservationUid string    `json:"reservationUid"`
+	UserName       string    `json:"userName"`
+	Body           []byte    `json:"body"`
+	Upstream       string    `json:"upstream,omitempty"`
+	Attempt        int       `json:"attempt"`
+	CreatedAt      time.Time `json:"createdAt"`
+	NextAttemptAt  time.Time `json:"nextAttemptAt"`
+	LastError      string    `json:"lastError,omitempty"`
+}
+
+type storage interface {
+	Put(cmd *Command) error
+	Delete(id uint64) error
+	List() ([]Command, error)
+	Bury(cmd Command) error
+	ListDead() ([]Command, error)
+	Get(id uint64) (Command, bool, error)
+	Revive(cmd Command) error
+	DeleteDead(id uint64) error
+}
+
+const defaultWorkers = 1
+
+// queue выполняет команды на пулах worker'ов, по пулу на каждый сервис, отказ которого отложил команду,
+// поэтому очередь команд к одному недоступному сервису не задерживает команды к остальным.
+type queue struct {
+	storage   storage
+	cfg       config.RetryQueue
+	mu        sync.Mutex
+	executors map[string]func(cmd Command) error
+	timers    map[uint64]*time.Timer
+	lanes     map[string]chan Command
+	stopped   bool
+	done      chan struct{}
+	workers   sync.WaitGroup
+	now       func() time.Time
+	random    func() float64
+}
+
+func NewQueue(cfg config.RetryQueue, storage storage) *queue {
+	return &queue{
+		storage: storage,
+		cfg:     cfg,
+		timers:  map[uint64]*time.Timer{},
+		lanes:   map[string]chan Command{},
+		done:    make(chan struct{}),
+		now:     time.Now,
+		random:  rand.Float64,
+	}
+}
+
+// Publish сохраняет новую команду на диск и планирует ее первую попытку. Команда считается принятой,
+// только если она сохранена: после перезапуска gateway она будет выполнена заново.
+func (q *queue) Publish(cmd Command) error {
+	now := q.now()
+	cmd.Attempt = 0
+	cmd.CreatedAt = now
+	cmd.NextAttemptAt = now.Add(q.delay(0))
+
+	err := q.storage.Put(&cmd)
+	if err != nil {
+		return err
+	}
+
+	q.schedule(cmd)
+	return nil
+}
+
+// Handle задает обработчики операций и планирует команды, сохраненные до перезапуска
+func (q *queue) Handle(executors map[string]func(cmd Command) error) {
+	q.mu.Lock()
+	q.executors = executors
+	q.mu.Unlock()
+
+	pending, err := q.storage.List()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load pending retries")
+	}
+	for _, cmd := range pending {
+		q.schedule(cmd)
+	}
+	if len(pending) > 0 {
+		log.Info().Msgf("replaying %d pending retries", len(pending))
+	}
+}
+
+// Stop прекращает планирование и ждет завершения выполняемых команд. Запланированные команды
+// остаются на диске и будут выполнены после перезапуска.
+func (q *queue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.done)
+		for id, timer := range q.timers {
+			timer.Stop()
+			delete(q.timers, id)
+		}
+	}
+	q.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending возвращает ожидающие команды
+func (q *queue) Pending() ([]Command, error) {
+	return q.storage.List()
+}
+
+// Dead возвращает команды, для которых повторы исчерпаны
+func (q *queue) Dead() ([]Command, error) {
+	return q.storage.ListDead()
+}
+
+// Replay планирует команду на немедленное выполнение. Команда из dead-letter возвращается в очередь
+// с новым отсчетом попыток и ttl.
+func (q *queue) Replay(id uint64) (Command, error) {
+	cmd, dead, err := q.storage.Get(id)
+	if err != nil {
+		return Command{}, err
+	}
+
+	now := q.now()
+	cmd.NextAttemptAt = now
+	if dead {
+		cmd.Attempt = 0
+		cmd.CreatedAt = now
+		err = q.storage.Revive(cmd)
+	} else {
+		if !q.unschedule(id) {
+			return Command{}, errCommandInProgress
+		}
+		err = q.storage.Put(&cmd)
+	}
+	if err != nil {
+		return Command{}, err
+	}
+
+	q.schedule(cmd)
+	return cmd, nil
+}
+
+// Discard удаляет команду без выполнения
+func (q *queue) Discard(id uint64) error {
+	_, dead, err := q.storage.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if dead {
+		return q.storage.DeleteDead(id)
+	}
+	if !q.unschedule(id) {
+		return errCommandInProgress
+	}
+	return q.storage.Delete(id)
+}
+
+func (q *queue) schedule(cmd Command) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped {
+		return
+	}
+
+	q.timers[cmd.ID] = time.AfterFunc(cmd.NextAttemptAt.Sub(q.now()), func() {
+		q.mu.Lock()
+		delete(q.timers, cmd.ID)
+		lane := q.lane(cmd.Upstream)
+		q.mu.Unlock()
+
+		select {
+		case lane <- cmd:
+		case <-q.done:
+		}
+	})
+}
+
+// lane возвращает канал пула worker'ов сервиса upstream, при первом обращении запуская пул.
+// Вызывается под q.mu.
+func (q *queue) lane(upstream string) chan Command {
+	lane, ok := q.lanes[upstream]
+	if ok {
+		return lane
+	}
+
+	workers, ok := q.cfg.UpstreamWorkers[upstream]
+	if !ok {
+		workers = q.cfg.Workers
+	}
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	lane = make(chan Command)
+	q.lanes[upstream] = lane
+	q.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work(lane)
+	}
+	return lane
+}
+
+func (q *queue) work(lane chan Command) {
+	defer q.workers.Done()
+	for {
+		select {
+		case <-q.done:
+			return
+		case cmd := <-lane:
+			q.execute(cmd)
+		}
+	}
+}
+
+func (q *queue) executor(operation string) (func(cmd Command) error, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	execute, ok := q.executors[operation]
+	return execute, ok
+}
+
+// unschedule отменяет запланированную попытку, false - команда уже выполняется
+func (q *queue) unschedule(id uint64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	timer, ok := q.timers[id]
+	if !ok || !timer.Stop() {
+		return false
+	}
+	delete(q.timers, id)
+	return true
+}
+
+// execute выполняет команду. Команда, завершившаяся ErrRetryLater, планируется снова с растущей задержкой,
+// пока не исчерпаны попытки. Исчерпавшие попытки и завершившиеся другой ошибкой команды уходят в dead-letter.
+func (q *queue) execute(cmd Command) {
+	logger := log.With().Uint64("id", cmd.ID).Str("operation", cmd.Operation).Str("upstream", cmd.Upstream).Int("attempt", cmd.Attempt).Logger()
+
+	execute, ok := q.executor(cmd.Operation)
+	if !ok {
+		logger.Error().Msg("unknown retry operation")
+		cmd.LastError = errUnknownOperation.Error()
+		q.bury(cmd)
+		return
+	}
+
+	err := execute(cmd)
+	if err == nil {
+		logger.Info().Msg("retry succeeded")
+		err = q.storage.Delete(cmd.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to delete retry")
+		}
+		return
+	}
+
+	cmd.Attempt++
+	cmd.LastError = err.Error()
+	if upstream := UpstreamOf(err); upstream != "" {
+		cmd.Upstream = upstream
+	}
+	if !errors.Is(err, ErrRetryLater) || q.exhausted(cmd) {
+		logger.Error().Err(err).Msg("retry failed permanently")
+		q.bury(cmd)
+		return
+	}
+
+	cmd.NextAttemptAt = q.now().Add(q.delay(cmd.Attempt))
+	logger.Warn().Err(err).Time("next_attempt_at", cmd.NextAttemptAt).Msg("retry failed, rescheduling")
+	err = q.storage.Put(&cmd)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to reschedule retry")
+		return
+	}
+	q.schedule(cmd)
+}
+
+func (q *queue) bury(cmd Command) {
+	err := q.storage.Bury(cmd)
+	if err != nil {
+		log.Error().Err(err).Uint64("id", cmd.ID).Msg("failed to move retry to dead-letter")
+	}
+}
+
+func (q *queue) exhausted(cmd Command) bool {
+	if q.cfg.MaxAttempts > 0 && cmd.Attempt >= q.cfg.MaxAttempts {
+		return true
+	}
+	return q.cfg.TTL > 0 && q.now().Sub(cmd.CreatedAt) >= q.cfg.TTL
+}
+
+// delay - задержка перед попыткой attempt: initial_delay * multiplier^attempt, не больше max_delay, ± jitter
+func (q *queue) delay(attempt int) time.Duration {
+	multiplier := q.cfg.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+
+	d := float64(q.cfg.InitialDelay) * math.Pow(multiplier, float64(attempt))
+	if q.cfg.MaxDelay > 0 && d > float64(q.cfg.MaxDelay) {
+		d = float64(q.cfg.MaxDelay)
+	}
+	if q.cfg.Jitter > 0 {
+		d += d * q.cfg.Jitter * (2*q.random() - 1)
+	}
+	return time.Duration(d)
+}
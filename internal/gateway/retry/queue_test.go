@@ -0,0 +1,300 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) *boltStorage {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "retry.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close() })
+	return storage
+}
+
+func errRetryLaterFor(cause string) error {
+	return fmt.Errorf("%w: %w", ErrRetryLater, errors.New(cause))
+}
+
+func Test_StorageSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry.db")
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	first := Command{Operation: "returnBook", ReservationUid: "first"}
+	second := Command{Operation: "returnBook", ReservationUid: "second"}
+	dead := Command{Operation: "returnBook", ReservationUid: "dead"}
+	require.NoError(t, storage.Put(&first))
+	require.NoError(t, storage.Put(&second))
+	require.NoError(t, storage.Put(&dead))
+	require.NotZero(t, first.ID)
+	require.NotEqual(t, first.ID, second.ID)
+	require.NoError(t, storage.Delete(first.ID))
+	require.NoError(t, storage.Bury(dead))
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	pending, err := storage.List()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, "second", pending[0].ReservationUid)
+
+	buried, err := storage.ListDead()
+	require.NoError(t, err)
+	require.Len(t, buried, 1)
+	require.Equal(t, "dead", buried[0].ReservationUid)
+}
+
+func Test_QueueReplaysPendingCommands(t *testing.T) {
+	storage := newTestStorage(t)
+
+	stored := Command{
+		Operation:      "returnBook",
+		ReservationUid: "test",
+		UserName:       "Test Max",
+		Body:           []byte(`{"condition":"GOOD"}`),
+		NextAttemptAt:  time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+	require.NoError(t, storage.Put(&stored))
+
+	calls := make(chan Command, 1)
+
+	q := NewQueue(config.RetryQueue{InitialDelay: time.Millisecond}, storage)
+	q.Handle(map[string]func(cmd Command) error{
+		"returnBook": func(cmd Command) error {
+			calls <- cmd
+			return nil
+		},
+	})
+
+	select {
+	case got := <-calls:
+		require.Equal(t, stored, got)
+	case <-time.After(time.Second):
+		t.Fatal("pending command was not replayed")
+	}
+
+	requireStored(t, storage, 0, 0)
+}
+
+func Test_QueueReschedulesDeferredCommand(t *testing.T) {
+	storage := newTestStorage(t)
+	attempts := make(chan int, 3)
+
+	q := NewQueue(config.RetryQueue{InitialDelay: time.Millisecond, Multiplier: 2}, storage)
+	q.Handle(map[string]func(cmd Command) error{
+		"returnBook": func(cmd Command) error {
+			attempts <- cmd.Attempt
+			if cmd.Attempt < 2 {
+				return errRetryLaterFor("connection refused")
+			}
+			return nil
+		},
+	})
+
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "test"}))
+
+	for expected := 0; expected <= 2; expected++ {
+		select {
+		case attempt := <-attempts:
+			require.Equal(t, expected, attempt)
+		case <-time.After(time.Second):
+			t.Fatal("deferred command was not retried")
+		}
+	}
+
+	requireStored(t, storage, 0, 0)
+}
+
+func Test_QueueDeadLetters(t *testing.T) {
+	var tests = []struct {
+		name      string
+		cfg       config.RetryQueue
+		err       error
+		operation string
+		attempts  int
+		lastError string
+	}{
+		{
+			name:      "max attempts exhausted",
+			cfg:       config.RetryQueue{InitialDelay: time.Millisecond, MaxAttempts: 3},
+			err:       errRetryLaterFor("connection refused"),
+			operation: "returnBook",
+			attempts:  3,
+			lastError: "operation must be retried later: connection refused",
+		},
+		{
+			name:      "ttl expired",
+			cfg:       config.RetryQueue{InitialDelay: time.Millisecond, TTL: time.Nanosecond},
+			err:       errRetryLaterFor("connection refused"),
+			operation: "returnBook",
+			attempts:  1,
+			lastError: "operation must be retried later: connection refused",
+		},
+		{
+			name:      "permanent error",
+			cfg:       config.RetryQueue{InitialDelay: time.Millisecond},
+			err:       errors.New("reservation not found"),
+			operation: "returnBook",
+			attempts:  1,
+			lastError: "reservation not found",
+		},
+		{
+			name:      "unknown operation",
+			cfg:       config.RetryQueue{InitialDelay: time.Millisecond},
+			operation: "unknown",
+			lastError: errUnknownOperation.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newTestStorage(t)
+
+			q := NewQueue(tt.cfg, storage)
+			q.Handle(map[string]func(cmd Command) error{
+				"returnBook": func(cmd Command) error { return tt.err },
+			})
+
+			require.NoError(t, q.Publish(Command{Operation: tt.operation, ReservationUid: "test"}))
+
+			requireStored(t, storage, 0, 1)
+			dead, err := q.Dead()
+			require.NoError(t, err)
+			require.Equal(t, "test", dead[0].ReservationUid)
+			require.Equal(t, tt.attempts, dead[0].Attempt)
+			require.Equal(t, tt.lastError, dead[0].LastError)
+		})
+	}
+}
+
+func Test_QueueDelay(t *testing.T) {
+	var tests = []struct {
+		name     string
+		cfg      config.RetryQueue
+		random   float64
+		attempt  int
+		expected time.Duration
+	}{
+		{name: "first attempt", cfg: config.RetryQueue{InitialDelay: 2 * time.Second, Multiplier: 2}, attempt: 0, expected: 2 * time.Second},
+		{name: "exponential growth", cfg: config.RetryQueue{InitialDelay: 2 * time.Second, Multiplier: 2}, attempt: 2, expected: 8 * time.Second},
+		{name: "capped by max delay", cfg: config.RetryQueue{InitialDelay: 2 * time.Second, Multiplier: 2, MaxDelay: 10 * time.Second}, attempt: 5, expected: 10 * time.Second},
+		{name: "zero multiplier keeps delay", cfg: config.RetryQueue{InitialDelay: 2 * time.Second}, attempt: 3, expected: 2 * time.Second},
+		{name: "jitter lower bound", cfg: config.RetryQueue{InitialDelay: 10 * time.Second, Jitter: 0.2}, random: 0, expected: 8 * time.Second},
+		{name: "jitter upper bound", cfg: config.RetryQueue{InitialDelay: 10 * time.Second, Jitter: 0.2}, random: 1, expected: 12 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(tt.cfg, nil)
+			q.random = func() float64 { return tt.random }
+
+			require.Equal(t, tt.expected, q.delay(tt.attempt))
+		})
+	}
+}
+
+func requireStored(t *testing.T, storage *boltStorage, pending, dead int) {
+	require.Eventually(t, func() bool {
+		p, err := storage.List()
+		if err != nil || len(p) != pending {
+			return false
+		}
+		d, err := storage.ListDead()
+		return err == nil && len(d) == dead
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_QueueIsolatesUpstreams(t *testing.T) {
+	storage := newTestStorage(t)
+	release := make(chan struct{})
+	executed := make(chan string, 2)
+
+	q := NewQueue(config.RetryQueue{InitialDelay: time.Millisecond, Workers: 1}, storage)
+	q.Handle(map[string]func(cmd Command) error{
+		"returnBook": func(cmd Command) error {
+			if cmd.Upstream == "rating" {
+				<-release
+			}
+			executed <- cmd.ReservationUid
+			return nil
+		},
+	})
+
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "slow", Upstream: "rating"}))
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "blocked", Upstream: "rating"}))
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "fast", Upstream: "library"}))
+
+	select {
+	case uid := <-executed:
+		require.Equal(t, "fast", uid)
+	case <-time.After(time.Second):
+		t.Fatal("library command was starved by rating backlog")
+	}
+
+	close(release)
+	requireStored(t, storage, 0, 0)
+}
+
+func Test_QueueRecordsDeferringUpstream(t *testing.T) {
+	storage := newTestStorage(t)
+
+	q := NewQueue(config.RetryQueue{InitialDelay: time.Millisecond, MaxAttempts: 1}, storage)
+	q.Handle(map[string]func(cmd Command) error{
+		"returnBook": func(cmd Command) error {
+			return Later("rating", errors.New("connection refused"))
+		},
+	})
+
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "test", Upstream: "library"}))
+
+	requireStored(t, storage, 0, 1)
+	dead, err := q.Dead()
+	require.NoError(t, err)
+	require.Equal(t, "rating", dead[0].Upstream)
+	require.Equal(t, "operation must be retried later: connection refused", dead[0].LastError)
+}
+
+func Test_QueueStopDrainsInFlightCommands(t *testing.T) {
+	storage := newTestStorage(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	q := NewQueue(config.RetryQueue{InitialDelay: time.Millisecond}, storage)
+	q.Handle(map[string]func(cmd Command) error{
+		"returnBook": func(cmd Command) error {
+			if cmd.ReservationUid == "in-flight" {
+				close(started)
+				<-release
+			}
+			return nil
+		},
+	})
+
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "in-flight"}))
+	<-started
+	require.NoError(t, q.Publish(Command{Operation: "returnBook", ReservationUid: "scheduled"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, q.Stop(ctx), context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, q.Stop(context.Background()))
+
+	// выполненная команда удалена, ожидавшая свободного worker'а осталась до перезапуска
+	pending, err := storage.List()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, "scheduled", pending[0].ReservationUid)
+}
@@ -0,0 +1,179 @@
+package retry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	storageOpenTimeout = time.Second
+	storageFileMode    = 0o600
+	storageDirMode     = 0o700
+)
+
+var (
+	pendingBucket = []byte("retries")
+	deadBucket    = []byte("dead_retries")
+)
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// NewStorage открывает (или создает) файл очереди по пути path
+func NewStorage(path string) (*boltStorage, error) {
+	err := os.MkdirAll(filepath.Dir(path), storageDirMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create retry storage dir")
+	}
+
+	db, err := bolt.Open(path, storageFileMode, &bolt.Options{Timeout: storageOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open retry storage")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{pendingBucket, deadBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "failed to create retry buckets")
+	}
+
+	return &boltStorage{db: db}, nil
+}
+
+// Put сохраняет ожидающую команду, новой команде (ID == 0) назначается идентификатор
+func (s *boltStorage) Put(cmd *Command) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pendingBucket)
+		if cmd.ID == 0 {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			cmd.ID = id
+		}
+		return put(b, *cmd)
+	})
+	return errors.Wrap(err, "failed to put retry")
+}
+
+func (s *boltStorage) Delete(id uint64) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).Delete(key(id))
+	})
+	return errors.Wrap(err, "failed to delete retry")
+}
+
+// List возвращает ожидающие команды в порядке их приема
+func (s *boltStorage) List() ([]Command, error) {
+	res, err := s.list(pendingBucket)
+	return res, errors.Wrap(err, "failed to list retries")
+}
+
+// Bury переносит команду из ожидающих в dead-letter
+func (s *boltStorage) Bury(cmd Command) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(pendingBucket).Delete(key(cmd.ID))
+		if err != nil {
+			return err
+		}
+		return put(tx.Bucket(deadBucket), cmd)
+	})
+	return errors.Wrap(err, "failed to bury retry")
+}
+
+// ListDead возвращает команды из dead-letter в порядке их приема
+func (s *boltStorage) ListDead() ([]Command, error) {
+	res, err := s.list(deadBucket)
+	return res, errors.Wrap(err, "failed to list dead retries")
+}
+
+// Get возвращает команду по идентификатору и признак того, что она в dead-letter
+func (s *boltStorage) Get(id uint64) (Command, bool, error) {
+	var cmd Command
+	var dead bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(pendingBucket).Get(key(id))
+		if value == nil {
+			value = tx.Bucket(deadBucket).Get(key(id))
+			dead = true
+		}
+		if value == nil {
+			return errCommandNotFound
+		}
+		return json.Unmarshal(value, &cmd)
+	})
+	if err != nil {
+		return Command{}, false, errors.Wrap(err, "failed to get retry")
+	}
+	return cmd, dead, nil
+}
+
+// Revive переносит команду из dead-letter обратно в ожидающие
+func (s *boltStorage) Revive(cmd Command) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(deadBucket).Delete(key(cmd.ID))
+		if err != nil {
+			return err
+		}
+		return put(tx.Bucket(pendingBucket), cmd)
+	})
+	return errors.Wrap(err, "failed to revive retry")
+}
+
+func (s *boltStorage) DeleteDead(id uint64) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadBucket).Delete(key(id))
+	})
+	return errors.Wrap(err, "failed to delete dead retry")
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltStorage) list(bucket []byte) ([]Command, error) {
+	res := make([]Command, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, value []byte) error {
+			var cmd Command
+			err := json.Unmarshal(value, &cmd)
+			if err != nil {
+				return err
+			}
+			res = append(res, cmd)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func put(b *bolt.Bucket, cmd Command) error {
+	value, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(cmd.ID), value)
+}
+
+func key(id uint64) []byte {
+	res := make([]byte, 8)
+	binary.BigEndian.PutUint64(res, id)
+	return res
+}
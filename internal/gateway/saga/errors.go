@@ -0,0 +1,44 @@
+package saga
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrCompensationPending - откат саги не завершен, его продолжит восстановление
+	ErrCompensationPending = errors.New("saga compensation pending")
+	// ErrStepsDeferred - сага прошла точку невозврата, но часть шагов после нее не выполнена и передана на повтор
+	ErrStepsDeferred = errors.New("saga steps deferred")
+
+	errUnknownSaga = errors.New("unknown saga type")
+)
+
+// StepError - шаг Step саги завершился ошибкой Err. Если шаг до точки невозврата, выполненные до него шаги
+// откатаны, если Compensated, иначе откат будет продолжен в фоне. Если после - сага не откатывается,
+// а невыполненные шаги Deferred переданы на повтор.
+type StepError struct {
+	Step        string
+	Err         error
+	Compensated bool
+	Deferred    []string
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("saga step %s failed: %s", e.Step, e.Err)
+}
+
+func (e *StepError) Is(target error) bool {
+	switch target {
+	case ErrCompensationPending:
+		return !e.Compensated && len(e.Deferred) == 0
+	case ErrStepsDeferred:
+		return len(e.Deferred) > 0
+	default:
+		return false
+	}
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
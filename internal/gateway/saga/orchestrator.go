@@ -0,0 +1,382 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateRunning      State = "running"
+	StateCompensating State = "compensating"
+	StateCommitted    State = "committed"
+)
+
+// Step - шаг саги: действие и его компенсация (nil, если откатывать нечего).
+// Retriable шаги идут после точки невозврата: они не откатываются, а при ошибке повторяются.
+type Step struct {
+	Name       string
+	Action     func() error
+	Compensate func() error
+	Retriable  bool
+}
+
+// Definition описывает сагу: NewState создает пустые данные саги, Steps строит шаги над ними.
+// Данные сохраняются в журнал после каждого шага, поэтому шаги должны записывать в них все,
+// что понадобится следующим шагам и компенсациям после перезапуска.
+// Defer сохраняет невыполненные Retriable шаги для повтора, обязателен, если такие шаги есть.
+type Definition struct {
+	NewState func() any
+	Steps    func(state any) []Step
+	Defer    func(state any, steps []string) error
+}
+
+// Log - запись журнала незавершенной саги. Completed - число выполненных и еще не откатанных шагов,
+// после точки невозврата - число пройденных шагов, из которых Deferred завершились ошибкой.
+// Started - шаг с номером Completed начат, но не завершен: его результат неизвестен, поэтому он тоже откатывается.
+type Log struct {
+	ID        uint64          `json:"id"`
+	Type      string          `json:"type"`
+	State     State           `json:"state"`
+	Data      json.RawMessage `json:"data"`
+	Completed int             `json:"completed"`
+	Started   bool            `json:"started,omitempty"`
+	Deferred  []string        `json:"deferred,omitempty"`
+	LastError string          `json:"lastError,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+type storage interface {
+	Put(log *Log) error
+	Delete(id uint64) error
+	List() ([]Log, error)
+}
+
+type orchestrator struct {
+	storage     storage
+	cfg         config.Saga
+	mu          sync.Mutex
+	definitions map[string]Definition
+	active      map[uint64]struct{}
+	done        chan struct{}
+	stopped     bool
+	recovering  sync.WaitGroup
+	now         func() time.Time
+}
+
+func NewOrchestrator(cfg config.Saga, storage storage) *orchestrator {
+	return &orchestrator{
+		storage: storage,
+		cfg:     cfg,
+		active:  map[uint64]struct{}{},
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+// Handle задает определения саг, откатывает саги, прерванные перезапуском, и каждые recovery_interval
+// продолжает откаты, которые не удалось завершить.
+func (o *orchestrator) Handle(definitions map[string]Definition) {
+	o.mu.Lock()
+	o.definitions = definitions
+	o.mu.Unlock()
+
+	o.recover()
+
+	o.recovering.Add(1)
+	go func() {
+		defer o.recovering.Done()
+
+		ticker := time.NewTicker(o.cfg.RecoveryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-ticker.C:
+				o.recover()
+			}
+		}
+	}()
+}
+
+// Stop останавливает фоновое восстановление и ждет завершения текущего прохода
+func (o *orchestrator) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	if !o.stopped {
+		o.stopped = true
+		close(o.done)
+	}
+	o.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		o.recovering.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Execute выполняет шаги саги sagaType над state по порядку. Если шаг завершился ошибкой, он и выполненные шаги
+// откатываются в обратном порядке и возвращается *StepError: шаг мог успеть примениться, например при таймауте. Откат, который не удалось завершить,
+// остается в журнале и продолжается в фоне. Ошибки Retriable шагов сагу не откатывают: см. commit.
+func (o *orchestrator) Execute(sagaType string, state any) error {
+	def, ok := o.definition(sagaType)
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownSaga, sagaType)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	now := o.now()
+	sagaLog := Log{Type: sagaType, State: StateRunning, Data: data, CreatedAt: now, UpdatedAt: now}
+
+	o.mu.Lock()
+	err = o.storage.Put(&sagaLog)
+	if err == nil {
+		o.active[sagaLog.ID] = struct{}{}
+	}
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer o.release(sagaLog.ID)
+
+	steps := def.Steps(state)
+	for sagaLog.Completed < len(steps) {
+		step := steps[sagaLog.Completed]
+		if step.Retriable {
+			return o.commit(def, &sagaLog, state, steps)
+		}
+
+		sagaLog.Started = true
+		err = o.save(&sagaLog, state)
+		if err == nil {
+			err = step.Action()
+		}
+		if err == nil {
+			sagaLog.Started = false
+			sagaLog.Completed++
+			err = o.save(&sagaLog, state)
+		}
+		if err != nil {
+			sagaLog.State = StateCompensating
+			sagaLog.LastError = fmt.Sprintf("%s: %s", step.Name, err)
+			return &StepError{Step: step.Name, Err: err, Compensated: o.compensate(&sagaLog, state, steps)}
+		}
+	}
+
+	err = o.storage.Delete(sagaLog.ID)
+	if err != nil {
+		log.Error().Err(err).Uint64("saga", sagaLog.ID).Msg("failed to delete saga log")
+	}
+	return nil
+}
+
+// commit выполняет шаги после точки невозврата. Каждый шаг выполняется один раз, шаги, завершившиеся ошибкой,
+// передаются в Definition.Defer и возвращаются в StepError.Deferred. Если передать их не удалось,
+// сага остается в журнале, и передачу повторит восстановление.
+func (o *orchestrator) commit(def Definition, sagaLog *Log, state any, steps []Step) error {
+	logger := log.With().Uint64("saga", sagaLog.ID).Str("type", sagaLog.Type).Logger()
+
+	sagaLog.State = StateCommitted
+	var stepErr *StepError
+	for sagaLog.Completed < len(steps) {
+		step := steps[sagaLog.Completed]
+
+		err := step.Action()
+		if err != nil {
+			logger.Warn().Err(err).Str("step", step.Name).Msg("saga step deferred")
+			sagaLog.Deferred = append(sagaLog.Deferred, step.Name)
+			if stepErr == nil {
+				stepErr = &StepError{Step: step.Name, Err: err}
+			}
+		}
+
+		sagaLog.Completed++
+		err = o.save(sagaLog, state)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to save saga log")
+		}
+	}
+
+	o.deferSteps(def, sagaLog, state, sagaLog.Deferred)
+	if stepErr == nil {
+		return nil
+	}
+	stepErr.Deferred = sagaLog.Deferred
+	return stepErr
+}
+
+// deferSteps передает шаги steps на повтор и удаляет сагу из журнала. При ошибке сага остается в журнале.
+func (o *orchestrator) deferSteps(def Definition, sagaLog *Log, state any, steps []string) bool {
+	logger := log.With().Uint64("saga", sagaLog.ID).Str("type", sagaLog.Type).Logger()
+
+	if len(steps) > 0 {
+		err := def.Defer(state, steps)
+		if err != nil {
+			logger.Error().Err(err).Strs("steps", steps).Msg("failed to defer saga steps")
+			sagaLog.LastError = fmt.Sprintf("defer: %s", err)
+			err = o.save(sagaLog, state)
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to save saga log")
+			}
+			return false
+		}
+	}
+
+	err := o.storage.Delete(sagaLog.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to delete saga log")
+	}
+	return true
+}
+
+// recover откатывает саги из журнала, которые сейчас не выполняются: прерванные перезапуском
+// и те, откат которых не удалось завершить. Для саг, прошедших точку невозврата, оставшиеся шаги
+// передаются на повтор.
+func (o *orchestrator) recover() {
+	o.mu.Lock()
+	logs, err := o.storage.List()
+	if err != nil {
+		o.mu.Unlock()
+		log.Error().Err(err).Msg("failed to load saga logs")
+		return
+	}
+	recovered := make([]Log, 0, len(logs))
+	for _, sagaLog := range logs {
+		if _, ok := o.active[sagaLog.ID]; !ok {
+			o.active[sagaLog.ID] = struct{}{}
+			recovered = append(recovered, sagaLog)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, sagaLog := range recovered {
+		o.recoverLog(sagaLog)
+		o.release(sagaLog.ID)
+	}
+}
+
+func (o *orchestrator) recoverLog(sagaLog Log) {
+	logger := log.With().Uint64("saga", sagaLog.ID).Str("type", sagaLog.Type).Str("state", string(sagaLog.State)).Logger()
+
+	def, ok := o.definition(sagaLog.Type)
+	if !ok {
+		logger.Error().Msg("unknown saga type, skipping recovery")
+		return
+	}
+
+	state := def.NewState()
+	err := json.Unmarshal(sagaLog.Data, state)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to decode saga state")
+		return
+	}
+
+	steps := def.Steps(state)
+	if sagaLog.State == StateCommitted {
+		// результат шага, прерванного перезапуском, неизвестен, поэтому он повторяется вместе с остальными
+		pending := append([]string(nil), sagaLog.Deferred...)
+		for _, step := range steps[sagaLog.Completed:] {
+			pending = append(pending, step.Name)
+		}
+		if o.deferSteps(def, &sagaLog, state, pending) {
+			logger.Info().Strs("steps", pending).Msg("saga steps deferred")
+		}
+		return
+	}
+
+	sagaLog.State = StateCompensating
+	if o.compensate(&sagaLog, state, steps) {
+		logger.Info().Msg("saga compensated")
+	}
+}
+
+// compensate откатывает начатый шаг и выполненные шаги в обратном порядке, сохраняя прогресс в журнал.
+// При неудаче оставляет сагу в журнале и возвращает false.
+func (o *orchestrator) compensate(sagaLog *Log, state any, steps []Step) bool {
+	logger := log.With().Uint64("saga", sagaLog.ID).Str("type", sagaLog.Type).Logger()
+
+	err := o.save(sagaLog, state)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to save saga log")
+	}
+
+	for sagaLog.Started || sagaLog.Completed > 0 {
+		i := sagaLog.Completed - 1
+		if sagaLog.Started {
+			i = sagaLog.Completed
+		}
+		step := steps[i]
+		if step.Compensate != nil {
+			err = step.Compensate()
+			if err != nil {
+				logger.Error().Err(err).Str("step", step.Name).Msg("saga compensation failed")
+				sagaLog.LastError = fmt.Sprintf("compensate %s: %s", step.Name, err)
+				err = o.save(sagaLog, state)
+				if err != nil {
+					logger.Error().Err(err).Msg("failed to save saga log")
+				}
+				return false
+			}
+		}
+
+		if sagaLog.Started {
+			sagaLog.Started = false
+		} else {
+			sagaLog.Completed--
+		}
+		err = o.save(sagaLog, state)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to save saga log")
+		}
+	}
+
+	err = o.storage.Delete(sagaLog.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to delete saga log")
+	}
+	return true
+}
+
+func (o *orchestrator) save(sagaLog *Log, state any) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	sagaLog.Data = data
+	sagaLog.UpdatedAt = o.now()
+	return o.storage.Put(sagaLog)
+}
+
+func (o *orchestrator) definition(sagaType string) (Definition, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	def, ok := o.definitions[sagaType]
+	return def, ok
+}
+
+func (o *orchestrator) release(id uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.active, id)
+}
@@ -0,0 +1,303 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"github.com/Erlendum/rsoi-lab-03/internal/gateway/config"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errStep = errors.New("step error")
+
+type testState struct {
+	Value string `json:"value"`
+}
+
+// recorder записывает выполненные действия и компенсации и позволяет заставить шаг завершиться ошибкой.
+// Действие из failApplied выполняется и только потом возвращает ошибку, как при таймауте ответа.
+type recorder struct {
+	mu          sync.Mutex
+	calls       []string
+	failAction  map[string]bool
+	failApplied map[string]bool
+	failUndo    map[string]bool
+	failDefer   bool
+	deferred    [][]string
+}
+
+func (r *recorder) record(call string, fail bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if fail {
+		return errStep
+	}
+	r.calls = append(r.calls, call)
+	return nil
+}
+
+func (r *recorder) setFailUndo(name string, fail bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failUndo[name] = fail
+}
+
+func (r *recorder) setFailDefer(fail bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failDefer = fail
+}
+
+func (r *recorder) deferredSteps() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([][]string(nil), r.deferred...)
+}
+
+func (r *recorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.calls...)
+}
+
+func (r *recorder) definitions() map[string]Definition {
+	step := func(s *testState, name string) Step {
+		return Step{
+			Name: name,
+			Action: func() error {
+				s.Value += name
+				err := r.record(name, r.failAction[name])
+				if err == nil && r.failApplied[name] {
+					return errStep
+				}
+				return err
+			},
+			Compensate: func() error {
+				r.mu.Lock()
+				fail := r.failUndo[name]
+				r.mu.Unlock()
+				return r.record("undo "+name+" "+s.Value, fail)
+			},
+		}
+	}
+
+	return map[string]Definition{
+		"test": {
+			NewState: func() any { return &testState{} },
+			Steps: func(state any) []Step {
+				s := state.(*testState)
+				return []Step{step(s, "a"), step(s, "b"), step(s, "c")}
+			},
+		},
+		"committed": {
+			NewState: func() any { return &testState{} },
+			Steps: func(state any) []Step {
+				s := state.(*testState)
+				retriable := func(step Step) Step {
+					step.Compensate = nil
+					step.Retriable = true
+					return step
+				}
+				return []Step{step(s, "a"), retriable(step(s, "b")), retriable(step(s, "c"))}
+			},
+			Defer: func(state any, steps []string) error {
+				r.mu.Lock()
+				defer r.mu.Unlock()
+
+				if r.failDefer {
+					return errStep
+				}
+				r.deferred = append(r.deferred, steps)
+				return nil
+			},
+		},
+	}
+}
+
+func newTestOrchestrator(t *testing.T, r *recorder) (*orchestrator, *boltStorage) {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "saga.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close() })
+
+	o := NewOrchestrator(config.Saga{RecoveryInterval: 10 * time.Millisecond}, storage)
+	o.Handle(r.definitions())
+	t.Cleanup(func() { _ = o.Stop(context.Background()) })
+	return o, storage
+}
+
+func newRecorder() *recorder {
+	return &recorder{failAction: map[string]bool{}, failApplied: map[string]bool{}, failUndo: map[string]bool{}}
+}
+
+func requireNoLogs(t *testing.T, storage *boltStorage) {
+	require.Eventually(t, func() bool {
+		logs, err := storage.List()
+		return err == nil && len(logs) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_ExecuteCompletes(t *testing.T) {
+	r := newRecorder()
+	o, storage := newTestOrchestrator(t, r)
+
+	err := o.Execute("test", &testState{})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, r.recorded())
+	requireNoLogs(t, storage)
+}
+
+func Test_ExecuteCompensatesInReverseOrder(t *testing.T) {
+	r := newRecorder()
+	r.failAction["c"] = true
+	o, storage := newTestOrchestrator(t, r)
+
+	err := o.Execute("test", &testState{})
+
+	var stepErr *StepError
+	require.ErrorAs(t, err, &stepErr)
+	require.Equal(t, "c", stepErr.Step)
+	require.True(t, stepErr.Compensated)
+	require.ErrorIs(t, err, errStep)
+	require.NotErrorIs(t, err, ErrCompensationPending)
+	require.Equal(t, []string{"a", "b", "undo c abc", "undo b abc", "undo a abc"}, r.recorded())
+	requireNoLogs(t, storage)
+}
+
+func Test_ExecuteCompensatesStepFailedAfterApplying(t *testing.T) {
+	r := newRecorder()
+	r.failApplied["b"] = true
+	o, storage := newTestOrchestrator(t, r)
+
+	err := o.Execute("test", &testState{})
+
+	var stepErr *StepError
+	require.ErrorAs(t, err, &stepErr)
+	require.Equal(t, "b", stepErr.Step)
+	require.True(t, stepErr.Compensated)
+	require.Equal(t, []string{"a", "b", "undo b ab", "undo a ab"}, r.recorded())
+	requireNoLogs(t, storage)
+}
+
+func Test_FailedCompensationIsRetriedInBackground(t *testing.T) {
+	r := newRecorder()
+	r.failAction["c"] = true
+	r.failUndo["a"] = true
+	o, storage := newTestOrchestrator(t, r)
+
+	err := o.Execute("test", &testState{})
+
+	require.ErrorIs(t, err, ErrCompensationPending)
+	logs, err := storage.List()
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	require.Equal(t, StateCompensating, logs[0].State)
+	require.Equal(t, 1, logs[0].Completed)
+	require.False(t, logs[0].Started)
+	require.Equal(t, "compensate a: step error", logs[0].LastError)
+
+	r.setFailUndo("a", false)
+
+	requireNoLogs(t, storage)
+	require.Equal(t, []string{"a", "b", "undo c abc", "undo b abc", "undo a abc"}, r.recorded())
+}
+
+func Test_InterruptedSagaIsCompensatedOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saga.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	// сага прервана перезапуском после двух выполненных шагов
+	require.NoError(t, storage.Put(&Log{Type: "test", State: StateRunning, Data: []byte(`{"value":"ab"}`), Completed: 2}))
+
+	r := newRecorder()
+	o := NewOrchestrator(config.Saga{RecoveryInterval: time.Minute}, storage)
+	o.Handle(r.definitions())
+	defer o.Stop(context.Background())
+	defer storage.Close()
+
+	require.Equal(t, []string{"undo b ab", "undo a ab"}, r.recorded())
+	requireNoLogs(t, storage)
+}
+
+func Test_InterruptedStepIsCompensatedOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saga.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	// сага прервана перезапуском во время шага b, его результат неизвестен
+	require.NoError(t, storage.Put(&Log{Type: "test", State: StateRunning, Data: []byte(`{"value":"a"}`), Completed: 1, Started: true}))
+
+	r := newRecorder()
+	o := NewOrchestrator(config.Saga{RecoveryInterval: time.Minute}, storage)
+	o.Handle(r.definitions())
+	defer o.Stop(context.Background())
+	defer storage.Close()
+
+	require.Equal(t, []string{"undo b a", "undo a a"}, r.recorded())
+	requireNoLogs(t, storage)
+}
+
+func Test_RetriableStepIsDeferredWithoutCompensation(t *testing.T) {
+	r := newRecorder()
+	r.failAction["b"] = true
+	o, storage := newTestOrchestrator(t, r)
+
+	err := o.Execute("committed", &testState{})
+
+	var stepErr *StepError
+	require.ErrorAs(t, err, &stepErr)
+	require.Equal(t, "b", stepErr.Step)
+	require.Equal(t, []string{"b"}, stepErr.Deferred)
+	require.ErrorIs(t, err, ErrStepsDeferred)
+	require.NotErrorIs(t, err, ErrCompensationPending)
+	require.Equal(t, []string{"a", "c"}, r.recorded())
+	require.Equal(t, [][]string{{"b"}}, r.deferredSteps())
+	requireNoLogs(t, storage)
+}
+
+func Test_FailedDeferIsRetriedInBackground(t *testing.T) {
+	r := newRecorder()
+	r.failAction["c"] = true
+	r.failDefer = true
+	o, storage := newTestOrchestrator(t, r)
+
+	err := o.Execute("committed", &testState{})
+
+	require.ErrorIs(t, err, ErrStepsDeferred)
+	logs, err := storage.List()
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	require.Equal(t, StateCommitted, logs[0].State)
+	require.Equal(t, []string{"c"}, logs[0].Deferred)
+
+	r.setFailDefer(false)
+
+	requireNoLogs(t, storage)
+	require.Equal(t, []string{"a", "b"}, r.recorded())
+	require.Equal(t, [][]string{{"c"}}, r.deferredSteps())
+}
+
+func Test_InterruptedCommittedSagaDefersRemainingSteps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saga.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	// сага прервана перезапуском во время шага c, шаг b завершился ошибкой
+	require.NoError(t, storage.Put(&Log{Type: "committed", State: StateCommitted, Data: []byte(`{"value":"abc"}`), Completed: 2, Deferred: []string{"b"}}))
+
+	r := newRecorder()
+	o := NewOrchestrator(config.Saga{RecoveryInterval: time.Minute}, storage)
+	o.Handle(r.definitions())
+	defer o.Stop(context.Background())
+	defer storage.Close()
+
+	require.Empty(t, r.recorded())
+	require.Equal(t, [][]string{{"b", "c"}}, r.deferredSteps())
+	requireNoLogs(t, storage)
+}
@@ -0,0 +1,105 @@
+package saga
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	storageOpenTimeout = time.Second
+	storageFileMode    = 0o600
+	storageDirMode     = 0o700
+)
+
+var sagaBucket = []byte("sagas")
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// NewStorage открывает (или создает) файл журнала саг по пути path
+func NewStorage(path string) (*boltStorage, error) {
+	err := os.MkdirAll(filepath.Dir(path), storageDirMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create saga storage dir")
+	}
+
+	db, err := bolt.Open(path, storageFileMode, &bolt.Options{Timeout: storageOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open saga storage")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sagaBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "failed to create saga bucket")
+	}
+
+	return &boltStorage{db: db}, nil
+}
+
+// Put сохраняет запись журнала, новой записи (ID == 0) назначается идентификатор
+func (s *boltStorage) Put(log *Log) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sagaBucket)
+		if log.ID == 0 {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			log.ID = id
+		}
+
+		value, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		return b.Put(key(log.ID), value)
+	})
+	return errors.Wrap(err, "failed to put saga log")
+}
+
+func (s *boltStorage) Delete(id uint64) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sagaBucket).Delete(key(id))
+	})
+	return errors.Wrap(err, "failed to delete saga log")
+}
+
+// List возвращает незавершенные саги в порядке их запуска
+func (s *boltStorage) List() ([]Log, error) {
+	res := make([]Log, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sagaBucket).ForEach(func(_, value []byte) error {
+			var log Log
+			err := json.Unmarshal(value, &log)
+			if err != nil {
+				return err
+			}
+			res = append(res, log)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list saga logs")
+	}
+	return res, nil
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
+
+func key(id uint64) []byte {
+	res := make([]byte, 8)
+	binary.BigEndian.PutUint64(res, id)
+	return res
+}
@@ -16,15 +16,22 @@ type PostgreSQL struct {
 	DSN string `env:"POSTGRESQL_DSN"`
 }
 
+// Admin - доступ к административному API, токен задается переменной окружения ADMIN_TOKEN
+type Admin struct {
+	Token string `env:"ADMIN_TOKEN"`
+}
+
 type Config struct {
 	Server     Server `yaml:"server"`
 	PostgreSQL PostgreSQL
+	Admin      Admin
 }
 
 func New() (*Config, error) {
 	cfg := &Config{}
 
 	cfg.PostgreSQL.DSN = os.Getenv("POSTGRESQL_DSN")
+	cfg.Admin.Token = os.Getenv("ADMIN_TOKEN")
 
 	yamlFile, err := os.ReadFile(fmt.Sprint("./configs/library-system/config.yml"))
 	if err != nil {
@@ -16,8 +16,22 @@ type libraryHandler interface {
 	GetLibraries(c echo.Context) error
 	GetBooksByLibrary(c echo.Context) error
 	GetBooksByUids(c echo.Context) error
+	SearchBooks(c echo.Context) error
 	GetLibrariesByUids(c echo.Context) error
 	UpdateBooksAvailableCount(c echo.Context) error
+	GetBookCopies(c echo.Context) error
+	GetCopyByReservation(c echo.Context) error
+	UpdateCopyCondition(c echo.Context) error
+	CreateLibrary(c echo.Context) error
+	UpdateLibrary(c echo.Context) error
+	DeleteLibrary(c echo.Context) error
+	CreateBook(c echo.Context) error
+	UpdateBook(c echo.Context) error
+	DeleteBook(c echo.Context) error
+	SetLibraryBookStock(c echo.Context) error
+	DeleteLibraryBook(c echo.Context) error
+	ImportCatalog(c echo.Context) error
+	ExportCatalog(c echo.Context) error
 }
 
 type server struct {
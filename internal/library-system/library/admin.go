@@ -0,0 +1,339 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+)
+
+type libraryRequest struct {
+	Name    string `json:"name" validate:"required,max=80"`
+	City    string `json:"city" validate:"required,max=255"`
+	Address string `json:"address" validate:"required,max=255"`
+}
+
+type libraryResp struct {
+	LibraryUid string `json:"libraryUid"`
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	City       string `json:"city"`
+}
+
+func (h *handler) CreateLibrary(c echo.Context) error {
+	req := &libraryRequest{}
+	if err := readRequest(c, req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": err.Error(),
+		})
+	}
+
+	l := library{
+		LibraryUid: uuid.NewString(),
+		Name:       req.Name,
+		Address:    req.Address,
+		City:       req.City,
+	}
+	err := h.storage.CreateLibrary(c.Request().Context(), l)
+	if err != nil {
+		log.Err(err).Msg("failed to create library")
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to create library",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, libraryResp{
+		LibraryUid: l.LibraryUid,
+		Name:       l.Name,
+		Address:    l.Address,
+		City:       l.City,
+	})
+}
+
+func (h *handler) UpdateLibrary(c echo.Context) error {
+	libraryUid := c.Param("libraryuid")
+	if _, err := uuid.Parse(libraryUid); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+
+	req := &libraryRequest{}
+	if err := readRequest(c, req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": err.Error(),
+		})
+	}
+
+	l := library{
+		LibraryUid: libraryUid,
+		Name:       req.Name,
+		Address:    req.Address,
+		City:       req.City,
+	}
+	err := h.storage.UpdateLibrary(c.Request().Context(), l)
+	if err != nil {
+		log.Err(err).Msg("failed to update library")
+		if errors.Is(err, errLibraryNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": errLibraryNotFound.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to update library",
+		})
+	}
+
+	return c.JSON(http.StatusOK, libraryResp{
+		LibraryUid: l.LibraryUid,
+		Name:       l.Name,
+		Address:    l.Address,
+		City:       l.City,
+	})
+}
+
+func (h *handler) DeleteLibrary(c echo.Context) error {
+	libraryUid := c.Param("libraryuid")
+	if _, err := uuid.Parse(libraryUid); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+
+	err := h.storage.DeleteLibrary(c.Request().Context(), libraryUid)
+	if err != nil {
+		log.Err(err).Msg("failed to delete library")
+		if errors.Is(err, errLibraryNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": errLibraryNotFound.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to delete library",
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// bookRequest - описание книги. Condition - состояние новых экземпляров, при создании по умолчанию EXCELLENT.
+type bookRequest struct {
+	Name      string `json:"name" validate:"required,max=255"`
+	Author    string `json:"author" validate:"max=255"`
+	Genre     string `json:"genre" validate:"max=255"`
+	Condition string `json:"condition" validate:"omitempty,oneof=EXCELLENT GOOD BAD"`
+}
+
+type bookResp struct {
+	BookUid   string `json:"bookUid"`
+	Name      string `json:"name"`
+	Author    string `json:"author"`
+	Genre     string `json:"genre"`
+	Condition string `json:"condition"`
+}
+
+func newBookResp(b book) bookResp {
+	return bookResp{
+		BookUid:   b.BookUid,
+		Name:      b.Name,
+		Author:    b.Author,
+		Genre:     b.Genre,
+		Condition: b.Condition,
+	}
+}
+
+func (h *handler) CreateBook(c echo.Context) error {
+	req := &bookRequest{}
+	if err := readRequest(c, req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": err.Error(),
+		})
+	}
+	if req.Condition == "" {
+		req.Condition = "EXCELLENT"
+	}
+
+	b := book{
+		BookUid:   uuid.NewString(),
+		Name:      req.Name,
+		Author:    req.Author,
+		Genre:     req.Genre,
+		Condition: req.Condition,
+	}
+	err := h.storage.CreateBook(c.Request().Context(), b)
+	if err != nil {
+		log.Err(err).Msg("failed to create book")
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to create book",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, newBookResp(b))
+}
+
+func (h *handler) UpdateBook(c echo.Context) error {
+	bookUid := c.Param("bookuid")
+	if _, err := uuid.Parse(bookUid); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+
+	req := &bookRequest{}
+	if err := readRequest(c, req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": err.Error(),
+		})
+	}
+	if req.Condition == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "condition is wrong",
+		})
+	}
+
+	b := book{
+		BookUid:   bookUid,
+		Name:      req.Name,
+		Author:    req.Author,
+		Genre:     req.Genre,
+		Condition: req.Condition,
+	}
+	err := h.storage.UpdateBook(c.Request().Context(), b)
+	if err != nil {
+		log.Err(err).Msg("failed to update book")
+		if errors.Is(err, errBookNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": errBookNotFound.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to update book",
+		})
+	}
+
+	return c.JSON(http.StatusOK, newBookResp(b))
+}
+
+func (h *handler) DeleteBook(c echo.Context) error {
+	bookUid := c.Param("bookuid")
+	if _, err := uuid.Parse(bookUid); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+
+	err := h.storage.DeleteBook(c.Request().Context(), bookUid)
+	if err != nil {
+		log.Err(err).Msg("failed to delete book")
+		if errors.Is(err, errBookNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": errBookNotFound.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to delete book",
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SetLibraryBookStock добавляет книгу в библиотеку и задает число доступных экземпляров
+func (h *handler) SetLibraryBookStock(c echo.Context) error {
+	libraryUid := c.Param("libraryuid")
+	bookUid := c.Param("bookuid")
+	if _, err := uuid.Parse(libraryUid); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+	if _, err := uuid.Parse(bookUid); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+
+	type request struct {
+		AvailableCount *int `json:"availableCount" validate:"required,min=0"`
+	}
+	type response struct {
+		LibraryUid     string `json:"libraryUid"`
+		BookUid        string `json:"bookUid"`
+		AvailableCount int    `json:"availableCount"`
+	}
+
+	req := &request{}
+	if err := readRequest(c, req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": err.Error(),
+		})
+	}
+
+	count, err := h.storage.SetLibraryBookStock(c.Request().Context(), libraryUid, bookUid, *req.AvailableCount)
+	if err != nil {
+		log.Err(err).Msg("failed to set library book stock")
+		if errors.Is(err, errRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": "record not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to set library book stock",
+		})
+	}
+
+	return c.JSON(http.StatusOK, response{
+		LibraryUid:     libraryUid,
+		BookUid:        bookUid,
+		AvailableCount: count,
+	})
+}
+
+func (h *handler) DeleteLibraryBook(c echo.Context) error {
+	libraryUid := c.Param("libraryuid")
+	bookUid := c.Param("bookuid")
+	if _, err := uuid.Parse(libraryUid); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+	if _, err := uuid.Parse(bookUid); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+
+	err := h.storage.DeleteLibraryBook(c.Request().Context(), libraryUid, bookUid)
+	if err != nil {
+		log.Err(err).Msg("failed to delete library book")
+		if errors.Is(err, errRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": "record not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to delete library book",
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// readRequest читает тело запроса в req и проверяет его, ошибка пригодна для ответа клиенту
+func readRequest(c echo.Context, req any) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		log.Err(err).Msg("failed to read body")
+		return errors.New("failed to read body")
+	}
+
+	if err = json.Unmarshal(body, req); err != nil {
+		log.Err(err).Msg("failed to unmarshal body")
+		return errors.New("failed to unmarshal body")
+	}
+
+	return c.Validate(req)
+}
@@ -0,0 +1,391 @@
+package library
+
+import (
+	"errors"
+	"github.com/Erlendum/rsoi-lab-03/pkg/validation"
+	"github.com/go-playground/validator/v10"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testLibraryUid = "83575e12-7ce0-48ee-9931-51919ff3c9ee"
+	testBookUid    = "f7cdc58f-2caf-4b15-9727-f89dcc629b27"
+)
+
+func Test_CreateLibrary(t *testing.T) {
+	type fields struct {
+		body             string
+		expectedHTTPCode int
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong body",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				body:             `{"name":`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: city is required",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				body:             `{"name":"test","address":"test"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: name is too long",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				body:             `{"name":"` + strings.Repeat("a", 81) + `","city":"test","address":"test"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 500: CreateLibrary error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				body:             `{"name":"test","city":"test","address":"test"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().CreateLibrary(gomock.Any(), gomock.Any()).Return(errors.New(""))
+			},
+		},
+		{
+			name: "http-code 201: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusCreated,
+				body:             `{"name":"test","city":"test","address":"test"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().CreateLibrary(gomock.Any(), gomock.Any()).DoAndReturn(func(_ any, l library) error {
+					require.NotEmpty(t, l.LibraryUid)
+					require.Equal(t, "test", l.City)
+					return nil
+				})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(tt.fields.body))
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			err := h.CreateLibrary(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+		})
+	}
+}
+
+func Test_UpdateBook(t *testing.T) {
+	type fields struct {
+		bookUid          string
+		body             string
+		expectedHTTPCode int
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong bookuid",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				bookUid:          "test",
+				body:             `{"name":"test","condition":"GOOD"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: wrong condition",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				bookUid:          testBookUid,
+				body:             `{"name":"test","condition":"test"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: condition is required",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				bookUid:          testBookUid,
+				body:             `{"name":"test"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 404: book not found",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+				bookUid:          testBookUid,
+				body:             `{"name":"test","condition":"GOOD"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateBook(gomock.Any(), book{BookUid: testBookUid, Name: "test", Condition: "GOOD"}).Return(errBookNotFound)
+			},
+		},
+		{
+			name: "http-code 200: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				bookUid:          testBookUid,
+				body:             `{"name":"test","author":"test","genre":"test","condition":"GOOD"}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateBook(gomock.Any(), book{BookUid: testBookUid, Name: "test", Author: "test", Genre: "test", Condition: "GOOD"}).Return(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodPut, "/test", strings.NewReader(tt.fields.body))
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("bookuid")
+			c.SetParamValues(tt.fields.bookUid)
+
+			err := h.UpdateBook(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+		})
+	}
+}
+
+func Test_SetLibraryBookStock(t *testing.T) {
+	type fields struct {
+		libraryUid       string
+		body             string
+		expectedHTTPCode int
+		expectedBody     string
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong libraryuid",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				libraryUid:       "test",
+				body:             `{"availableCount":1}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: availableCount is required",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				libraryUid:       testLibraryUid,
+				body:             `{}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: negative availableCount",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				libraryUid:       testLibraryUid,
+				body:             `{"availableCount":-1}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 404: library or book not found",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+				libraryUid:       testLibraryUid,
+				body:             `{"availableCount":1}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().SetLibraryBookStock(gomock.Any(), testLibraryUid, testBookUid, 1).Return(0, errRecordNotFound)
+			},
+		},
+		{
+			name: "http-code 500: SetLibraryBookStock error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				libraryUid:       testLibraryUid,
+				body:             `{"availableCount":1}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().SetLibraryBookStock(gomock.Any(), testLibraryUid, testBookUid, 1).Return(0, errors.New(""))
+			},
+		},
+		{
+			name: "http-code 200: zero stock",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				libraryUid:       testLibraryUid,
+				body:             `{"availableCount":0}`,
+				expectedBody:     `{"libraryUid":"` + testLibraryUid + `","bookUid":"` + testBookUid + `","availableCount":0}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().SetLibraryBookStock(gomock.Any(), testLibraryUid, testBookUid, 0).Return(0, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodPut, "/test", strings.NewReader(tt.fields.body))
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("libraryuid", "bookuid")
+			c.SetParamValues(tt.fields.libraryUid, testBookUid)
+
+			err := h.SetLibraryBookStock(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+			if tt.fields.expectedBody != "" {
+				require.JSONEq(t, tt.fields.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_DeleteLibraryBook(t *testing.T) {
+	type fields struct {
+		expectedHTTPCode int
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 404: record not found",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().DeleteLibraryBook(gomock.Any(), testLibraryUid, testBookUid).Return(errRecordNotFound)
+			},
+		},
+		{
+			name: "http-code 500: DeleteLibraryBook error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().DeleteLibraryBook(gomock.Any(), testLibraryUid, testBookUid).Return(errors.New(""))
+			},
+		},
+		{
+			name: "http-code 204: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusNoContent,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().DeleteLibraryBook(gomock.Any(), testLibraryUid, testBookUid).Return(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodDelete, "/test", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("libraryuid", "bookuid")
+			c.SetParamValues(testLibraryUid, testBookUid)
+
+			err := h.DeleteLibraryBook(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+		})
+	}
+}
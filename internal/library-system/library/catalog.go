@@ -0,0 +1,353 @@
+package library
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// форматы каталога
+const (
+	catalogFormatCSV  = "csv"
+	catalogFormatJSON = "json"
+)
+
+// maxImportRows - ограничение на число строк импорта: весь каталог импортируется в одной транзакции
+const maxImportRows = 10000
+
+// exportFlushRows - число строк экспорта, после которого ответ отправляется клиенту
+const exportFlushRows = 100
+
+// ImportCatalog импортирует каталог из CSV или JSON. С dryRun изменения проверяются и откатываются.
+// Если хотя бы одна строка не импортирована, не применяется ни одна, а в отчете указаны ошибки строк.
+func (h *handler) ImportCatalog(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = catalogFormatJSON
+		if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), "text/csv") {
+			format = catalogFormatCSV
+		}
+	}
+
+	dryRun := false
+	if dryRunParam := c.QueryParam("dryRun"); dryRunParam != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(dryRunParam)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "dryRun is wrong",
+			})
+		}
+	}
+
+	var rows []catalogRow
+	var rowErrs map[int]error
+	var err error
+	switch format {
+	case catalogFormatCSV:
+		rows, rowErrs, err = readCatalogCSV(c.Request().Body)
+	case catalogFormatJSON:
+		rows, err = readCatalogJSON(c.Request().Body)
+		rowErrs = map[int]error{}
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "format is wrong",
+		})
+	}
+	if err != nil {
+		log.Err(err).Msg("failed to read catalog")
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": err.Error(),
+		})
+	}
+	if len(rows) == 0 || len(rows) > maxImportRows {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": fmt.Sprintf("catalog must contain from 1 to %d rows", maxImportRows),
+		})
+	}
+
+	valid := make([]catalogRow, 0, len(rows))
+	for i, row := range rows {
+		if _, ok := rowErrs[i]; ok {
+			continue
+		}
+		if err = c.Validate(row); err != nil {
+			rowErrs[i] = err
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	// строки с ошибками не импортируются, поэтому остальные только проверяются
+	imported, err := h.storage.ImportCatalog(c.Request().Context(), valid, dryRun || len(rowErrs) > 0)
+	if err != nil {
+		log.Err(err).Msg("failed to import catalog")
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to import catalog",
+		})
+	}
+
+	type response struct {
+		DryRun    bool           `json:"dryRun"`
+		Applied   bool           `json:"applied"`
+		Total     int            `json:"total"`
+		Succeeded int            `json:"succeeded"`
+		Failed    int            `json:"failed"`
+		Rows      []importResult `json:"rows"`
+	}
+
+	res := response{DryRun: dryRun, Total: len(rows), Rows: make([]importResult, 0, len(rows))}
+	for i, row := range rows {
+		var result importResult
+		if rowErr, ok := rowErrs[i]; ok {
+			result = importResult{BookUid: row.BookUid, LibraryUid: row.LibraryUid, Status: importStatusError, Error: rowErr.Error()}
+		} else {
+			result, imported = imported[0], imported[1:]
+		}
+		result.Row = i + 1
+
+		if result.Status == importStatusError {
+			res.Failed++
+		} else {
+			res.Succeeded++
+		}
+		res.Rows = append(res.Rows, result)
+	}
+	res.Applied = !dryRun && res.Failed == 0
+
+	if res.Failed > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// readCatalogCSV читает каталог из CSV с заголовком из колонок catalogColumns в любом порядке.
+// Ошибки отдельных строк возвращаются по индексу строки, ошибка формата файла - целиком.
+func readCatalogCSV(r io.Reader) ([]catalogRow, map[int]error, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	known := map[string]struct{}{}
+	for _, column := range catalogColumns {
+		known[column] = struct{}{}
+	}
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		if _, ok := known[header[i]]; !ok {
+			return nil, nil, fmt.Errorf("unknown csv column: %s", header[i])
+		}
+	}
+
+	rows := make([]catalogRow, 0)
+	rowErrs := map[int]error{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, nil, fmt.Errorf("failed to read csv: %w", err)
+		}
+		if err != nil {
+			rowErrs[len(rows)] = err
+			rows = append(rows, catalogRow{})
+			continue
+		}
+
+		values := map[string]string{}
+		for i, value := range record {
+			values[header[i]] = strings.TrimSpace(value)
+		}
+
+		row := catalogRow{
+			BookUid:    values["bookUid"],
+			Isbn:       values["isbn"],
+			Name:       values["name"],
+			Author:     values["author"],
+			Genre:      values["genre"],
+			Condition:  values["condition"],
+			LibraryUid: values["libraryUid"],
+		}
+		if count := values["availableCount"]; count != "" {
+			availableCount, err := strconv.Atoi(count)
+			if err != nil {
+				rowErrs[len(rows)] = errors.New("availableCount is wrong")
+			} else {
+				row.AvailableCount = &availableCount
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, rowErrs, nil
+}
+
+// readCatalogJSON читает каталог из JSON-массива строк
+func readCatalogJSON(r io.Reader) ([]catalogRow, error) {
+	rows := make([]catalogRow, 0)
+	err := json.NewDecoder(r).Decode(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal body: %w", err)
+	}
+	return rows, nil
+}
+
+// ExportCatalog выгружает каталог в CSV или JSON по мере чтения из базы, не собирая его в памяти.
+// Ошибка после начала выгрузки только логируется: ответ уже отправлен клиенту.
+func (h *handler) ExportCatalog(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = catalogFormatJSON
+	}
+
+	var w catalogWriter
+	switch format {
+	case catalogFormatCSV:
+		w = &csvCatalogWriter{w: csv.NewWriter(c.Response())}
+	case catalogFormatJSON:
+		w = &jsonCatalogWriter{w: c.Response()}
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "format is wrong",
+		})
+	}
+
+	written := 0
+	start := func() error {
+		if c.Response().Committed {
+			return nil
+		}
+		c.Response().Header().Set(echo.HeaderContentType, w.contentType())
+		c.Response().WriteHeader(http.StatusOK)
+		return w.begin()
+	}
+
+	err := h.storage.ExportCatalog(c.Request().Context(), func(row catalogRow) error {
+		err := start()
+		if err != nil {
+			return err
+		}
+
+		err = w.write(row)
+		if err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushRows == 0 {
+			return w.flush(c.Response())
+		}
+		return nil
+	})
+	if err == nil {
+		err = start()
+	}
+	if err == nil {
+		err = w.end()
+	}
+	if err == nil {
+		err = w.flush(c.Response())
+	}
+	if err != nil {
+		log.Err(err).Msg("failed to export catalog")
+		if !c.Response().Committed {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "failed to export catalog",
+			})
+		}
+	}
+	return nil
+}
+
+// catalogWriter - запись строк каталога в ответ в одном из форматов
+type catalogWriter interface {
+	contentType() string
+	begin() error
+	write(row catalogRow) error
+	end() error
+	flush(resp *echo.Response) error
+}
+
+type csvCatalogWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvCatalogWriter) contentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (w *csvCatalogWriter) begin() error {
+	return w.w.Write(catalogColumns)
+}
+
+func (w *csvCatalogWriter) write(row catalogRow) error {
+	availableCount := ""
+	if row.AvailableCount != nil {
+		availableCount = strconv.Itoa(*row.AvailableCount)
+	}
+	return w.w.Write([]string{row.BookUid, row.Isbn, row.Name, row.Author, row.Genre, row.Condition, row.LibraryUid, availableCount})
+}
+
+func (w *csvCatalogWriter) end() error {
+	return nil
+}
+
+func (w *csvCatalogWriter) flush(resp *echo.Response) error {
+	w.w.Flush()
+	if err := w.w.Error(); err != nil {
+		return err
+	}
+	resp.Flush()
+	return nil
+}
+
+type jsonCatalogWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (w *jsonCatalogWriter) contentType() string {
+	return echo.MIMEApplicationJSON
+}
+
+func (w *jsonCatalogWriter) begin() error {
+	_, err := io.WriteString(w.w, "[")
+	return err
+}
+
+func (w *jsonCatalogWriter) write(row catalogRow) error {
+	if w.written {
+		if _, err := io.WriteString(w.w, ","); err != nil {
+			return err
+		}
+	}
+	w.written = true
+
+	body, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(body)
+	return err
+}
+
+func (w *jsonCatalogWriter) end() error {
+	_, err := io.WriteString(w.w, "]")
+	return err
+}
+
+func (w *jsonCatalogWriter) flush(resp *echo.Response) error {
+	resp.Flush()
+	return nil
+}
@@ -0,0 +1,244 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"github.com/Erlendum/rsoi-lab-03/pkg/validation"
+	"github.com/go-playground/validator/v10"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_ImportCatalog(t *testing.T) {
+	type fields struct {
+		query            string
+		contentType      string
+		body             string
+		expectedHTTPCode int
+		expectedBody     string
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+	count := 2
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong format",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				query:            "format=xml",
+				body:             `[]`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: unknown csv column",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				contentType:      "text/csv",
+				body:             "isbn,name,price\n978-5-17-090630-7,test,100\n",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: empty catalog",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				body:             `[]`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 500: ImportCatalog error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				body:             `[{"isbn":"978-5-17-090630-7","name":"test"}]`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().ImportCatalog(gomock.Any(), gomock.Any(), false).Return(nil, errors.New(""))
+			},
+		},
+		{
+			name: "http-code 422: invalid rows are reported and nothing is applied",
+			fields: fields{
+				expectedHTTPCode: http.StatusUnprocessableEntity,
+				contentType:      "text/csv",
+				body: "isbn,name,libraryUid,availableCount\n" +
+					"978-5-17-090630-7,test," + testLibraryUid + ",2\n" +
+					",test,,\n" +
+					"978-5-17-090631-4,test," + testLibraryUid + ",many\n",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				rows := []catalogRow{{Isbn: "978-5-17-090630-7", Name: "test", LibraryUid: testLibraryUid, AvailableCount: &count}}
+				fields.storage.EXPECT().ImportCatalog(gomock.Any(), rows, true).Return([]importResult{{BookUid: testBookUid, LibraryUid: testLibraryUid, Status: importStatusCreated}}, nil)
+			},
+		},
+		{
+			name: "http-code 200: dry run",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				query:            "dryRun=true",
+				body:             `[{"bookUid":"` + testBookUid + `","name":"test","libraryUid":"` + testLibraryUid + `","availableCount":2}]`,
+				expectedBody:     `{"dryRun":true,"applied":false,"total":1,"succeeded":1,"failed":0,"rows":[{"row":1,"bookUid":"` + testBookUid + `","libraryUid":"` + testLibraryUid + `","status":"UPDATED"}]}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				rows := []catalogRow{{BookUid: testBookUid, Name: "test", LibraryUid: testLibraryUid, AvailableCount: &count}}
+				fields.storage.EXPECT().ImportCatalog(gomock.Any(), rows, true).Return([]importResult{{BookUid: testBookUid, LibraryUid: testLibraryUid, Status: importStatusUpdated}}, nil)
+			},
+		},
+		{
+			name: "http-code 422: row failed in storage",
+			fields: fields{
+				expectedHTTPCode: http.StatusUnprocessableEntity,
+				body:             `[{"isbn":"978-5-17-090630-7","name":"test","libraryUid":"` + testLibraryUid + `","availableCount":2}]`,
+				expectedBody:     `{"dryRun":false,"applied":false,"total":1,"succeeded":0,"failed":1,"rows":[{"row":1,"libraryUid":"` + testLibraryUid + `","status":"ERROR","error":"library not found"}]}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().ImportCatalog(gomock.Any(), gomock.Any(), false).Return([]importResult{{LibraryUid: testLibraryUid, Status: importStatusError, Error: errLibraryNotFound.Error()}}, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodPost, "/test?"+tt.fields.query, strings.NewReader(tt.fields.body))
+			req.Header.Set(echo.HeaderContentType, tt.fields.contentType)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			err := h.ImportCatalog(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+			if tt.fields.expectedBody != "" {
+				require.JSONEq(t, tt.fields.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_ExportCatalog(t *testing.T) {
+	type fields struct {
+		format           string
+		expectedHTTPCode int
+		expectedBody     string
+	}
+
+	e := echo.New()
+	count := 3
+	rows := []catalogRow{
+		{BookUid: testBookUid, Isbn: "978-5-17-090630-7", Name: "Краткий курс C++", Author: "Бьерн Страуструп", Genre: "Научная фантастика", Condition: "EXCELLENT", LibraryUid: testLibraryUid, AvailableCount: &count},
+		{BookUid: testLibraryUid, Name: "test, with comma", Condition: "GOOD"},
+	}
+	export := func(_ context.Context, fn func(row catalogRow) error) error {
+		for _, row := range rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong format",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				format:           "xml",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 500: ExportCatalog error before first row",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				format:           "csv",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().ExportCatalog(gomock.Any(), gomock.Any()).Return(errors.New(""))
+			},
+		},
+		{
+			name: "http-code 200: csv",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				format:           "csv",
+				expectedBody: "bookUid,isbn,name,author,genre,condition,libraryUid,availableCount\n" +
+					testBookUid + ",978-5-17-090630-7,Краткий курс C++,Бьерн Страуструп,Научная фантастика,EXCELLENT," + testLibraryUid + ",3\n" +
+					testLibraryUid + ",,\"test, with comma\",,,GOOD,,\n",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().ExportCatalog(gomock.Any(), gomock.Any()).DoAndReturn(export)
+			},
+		},
+		{
+			name: "http-code 200: empty json",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				expectedBody:     `[]`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().ExportCatalog(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodGet, "/test?format="+tt.fields.format, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			err := h.ExportCatalog(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+			if tt.fields.expectedBody != "" {
+				require.Equal(t, tt.fields.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
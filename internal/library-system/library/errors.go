@@ -5,5 +5,13 @@ import "errors"
 var (
 	errLibraryNotFound = errors.New("library not found")
 	errBookNotFound    = errors.New("book not found")
+	errCopyNotFound    = errors.New("book copy not found")
 	errRecordNotFound  = errors.New("record not found")
+	errNotEnoughCopies = errors.New("not enough copies")
+
+	errOperationNotFound = errors.New("operation not found")
+	// errOperationApplied - операция с этим идентификатором уже применена
+	errOperationApplied = errors.New("operation already applied")
+	// errOperationConflict - идентификатор операции уже использован для другого изменения
+	errOperationConflict = errors.New("operation id is already used for another update")
 )
@@ -3,10 +3,14 @@ package library
 import (
 	"context"
 	"errors"
+	"github.com/Erlendum/rsoi-lab-03/internal/library-system/config"
+	"github.com/Erlendum/rsoi-lab-03/pkg/auth"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 //go:generate mockgen -source=handler.go -destination=handler_mocks.go -self_package=github.com/Erlendum/rsoi-lab-02/internal/library-system/library -package=library
@@ -14,18 +18,33 @@ import (
 type storage interface {
 	GetLibraries(ctx context.Context, city string, offset, limit int) ([]library, error)
 	GetBooksByLibrary(ctx context.Context, libraryUid string, offset, limit int, showAll bool) ([]book, error)
-	GetBooksAvailableCount(ctx context.Context, libraryUid, bookUid string) (int, error)
 	GetBooksByUids(ctx context.Context, uids []string) ([]book, error)
 	GetLibrariesByUids(ctx context.Context, uids []string) ([]library, error)
-	UpdateBooksAvailableCount(ctx context.Context, libraryUid, bookUid string, count int) error
+	UpdateBooksAvailableCount(ctx context.Context, libraryUid, bookUid string, countDiff int, reservationUid string, returned bool, op *operation) (int, error)
+	GetOperation(ctx context.Context, operationId string) (operation, error)
+	GetBookCopies(ctx context.Context, libraryUid, bookUid string) ([]bookCopy, error)
+	GetCopyByReservation(ctx context.Context, reservationUid string) (bookCopy, error)
+	UpdateCopyCondition(ctx context.Context, copyUid, condition string) error
+	CreateLibrary(ctx context.Context, l library) error
+	UpdateLibrary(ctx context.Context, l library) error
+	DeleteLibrary(ctx context.Context, libraryUid string) error
+	CreateBook(ctx context.Context, b book) error
+	UpdateBook(ctx context.Context, b book) error
+	DeleteBook(ctx context.Context, bookUid string) error
+	SetLibraryBookStock(ctx context.Context, libraryUid, bookUid string, availableCount int) (int, error)
+	DeleteLibraryBook(ctx context.Context, libraryUid, bookUid string) error
+	ImportCatalog(ctx context.Context, rows []catalogRow, dryRun bool) ([]importResult, error)
+	ExportCatalog(ctx context.Context, fn func(row catalogRow) error) error
+	SearchBooks(ctx context.Context, filter bookSearchFilter, offset, limit int) ([]foundBook, int, error)
 }
 
 type handler struct {
 	storage storage
+	cfg     config.Admin
 }
 
-func NewHandler(storage storage) *handler {
-	return &handler{storage: storage}
+func NewHandler(storage storage, cfg config.Admin) *handler {
+	return &handler{storage: storage, cfg: cfg}
 }
 
 func (h *handler) Register(echo *echo.Echo) {
@@ -34,8 +53,24 @@ func (h *handler) Register(echo *echo.Echo) {
 	api.GET("/libraries", h.GetLibraries)
 	api.GET("/libraries/:uid/books", h.GetBooksByLibrary)
 	api.GET("/books/", h.GetBooksByUids)
+	api.GET("/books/search", h.SearchBooks)
 	api.GET("/libraries/by-uids", h.GetLibrariesByUids)
 	api.PUT("/libraries/:libraryuid/books/:bookuid", h.UpdateBooksAvailableCount)
+	api.GET("/libraries/:libraryuid/books/:bookuid/copies", h.GetBookCopies)
+	api.GET("/copies/by-reservation/:reservationuid", h.GetCopyByReservation)
+	api.PUT("/copies/:copyuid/condition", h.UpdateCopyCondition)
+
+	admin := api.Group("/admin", auth.AdminToken(h.cfg.Token))
+	admin.POST("/libraries", h.CreateLibrary)
+	admin.PUT("/libraries/:libraryuid", h.UpdateLibrary)
+	admin.DELETE("/libraries/:libraryuid", h.DeleteLibrary)
+	admin.POST("/books", h.CreateBook)
+	admin.PUT("/books/:bookuid", h.UpdateBook)
+	admin.DELETE("/books/:bookuid", h.DeleteBook)
+	admin.PUT("/libraries/:libraryuid/books/:bookuid", h.SetLibraryBookStock)
+	admin.DELETE("/libraries/:libraryuid/books/:bookuid", h.DeleteLibraryBook)
+	admin.POST("/catalog/import", h.ImportCatalog)
+	admin.GET("/catalog/export", h.ExportCatalog)
 }
 
 func (h *handler) GetLibraries(c echo.Context) error {
@@ -187,6 +222,107 @@ func (h *handler) GetBooksByLibrary(c echo.Context) error {
 	return c.JSON(http.StatusOK, res)
 }
 
+// maxSearchQueryLength - ограничение длины поискового запроса
+const maxSearchQueryLength = 255
+
+// SearchBooks ищет книги по названию, автору и жанру во всех библиотеках. city ограничивает город библиотек,
+// available=true - только библиотеки с доступными экземплярами.
+func (h *handler) SearchBooks(c echo.Context) error {
+	query := strings.TrimSpace(c.QueryParam("query"))
+	if query == "" || len(query) > maxSearchQueryLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "query is wrong",
+		})
+	}
+
+	pageParam := c.QueryParam("page")
+	page, err := strconv.Atoi(pageParam)
+	if err != nil || page <= 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "page is wrong",
+		})
+	}
+
+	sizeParam := c.QueryParam("size")
+	size, err := strconv.Atoi(sizeParam)
+	if err != nil || size <= 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "size is wrong",
+		})
+	}
+
+	available := false
+	if availableParam := c.QueryParam("available"); availableParam != "" {
+		available, err = strconv.ParseBool(availableParam)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "available is wrong",
+			})
+		}
+	}
+
+	filter := bookSearchFilter{Query: query, City: c.QueryParam("city"), Available: available}
+	books, total, err := h.storage.SearchBooks(c.Request().Context(), filter, page*size-size, size)
+	if err != nil {
+		log.Err(err).Msg("failed to search books")
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to search books",
+		})
+	}
+
+	type libraryItem struct {
+		LibraryUid     string `json:"libraryUid"`
+		Name           string `json:"name"`
+		Address        string `json:"address"`
+		City           string `json:"city"`
+		AvailableCount int    `json:"availableCount"`
+	}
+	type item struct {
+		BookUid   string        `json:"bookUid"`
+		Name      string        `json:"name"`
+		Author    string        `json:"author"`
+		Genre     string        `json:"genre"`
+		Condition string        `json:"condition"`
+		Libraries []libraryItem `json:"libraries"`
+	}
+	type response struct {
+		Page          int    `json:"page"`
+		PageSize      int    `json:"pageSize"`
+		TotalElements int    `json:"totalElements"`
+		Items         []item `json:"items"`
+	}
+
+	items := make([]item, 0, len(books))
+	for _, v := range books {
+		libraries := make([]libraryItem, 0, len(v.Libraries))
+		for _, l := range v.Libraries {
+			libraries = append(libraries, libraryItem{
+				LibraryUid:     l.LibraryUid,
+				Name:           l.Name,
+				Address:        l.Address,
+				City:           l.City,
+				AvailableCount: l.AvailableCount,
+			})
+		}
+		items = append(items, item{
+			BookUid:   v.BookUid,
+			Name:      v.Name,
+			Author:    v.Author,
+			Genre:     v.Genre,
+			Condition: v.Condition,
+			Libraries: libraries,
+		})
+	}
+	res := response{
+		Page:          page,
+		PageSize:      size,
+		TotalElements: total,
+		Items:         items,
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
 func (h *handler) GetBooksByUids(c echo.Context) error {
 	uids := c.QueryParams()["bookUids"]
 	if len(uids) == 0 {
@@ -300,37 +436,199 @@ func (h *handler) UpdateBooksAvailableCount(c echo.Context) error {
 		})
 	}
 
-	actualCount, err := h.storage.GetBooksAvailableCount(c.Request().Context(), libraryUid, bookUid)
+	// выданные экземпляры привязываются к брони reservationUid и освобождаются по ней
+	reservationUid := c.QueryParam("reservationUid")
+	if reservationUid != "" {
+		if _, err = uuid.Parse(reservationUid); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "reservationUid is wrong",
+			})
+		}
+	}
+
+	// returned - возврат книги читателем, а не отмена выдачи
+	returned := false
+	if returnedParam := c.QueryParam("returned"); returnedParam != "" {
+		returned, err = strconv.ParseBool(returnedParam)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "returned is wrong",
+			})
+		}
+	}
+
+	// с operationId изменение применяется один раз, повтор возвращает исходный результат
+	var op *operation
+	if operationId := c.QueryParam("operationId"); operationId != "" {
+		op = &operation{ID: operationId, LibraryUid: libraryUid, BookUid: bookUid, CountDiff: countDiff}
+		err = h.checkOperation(c.Request().Context(), *op)
+		if err != nil {
+			return operationResponse(c, err)
+		}
+	}
+
+	_, err = h.storage.UpdateBooksAvailableCount(c.Request().Context(), libraryUid, bookUid, countDiff, reservationUid, returned, op)
+	if errors.Is(err, errOperationApplied) || errors.Is(err, errOperationConflict) {
+		return operationResponse(c, err)
+	}
 	if err != nil {
-		log.Err(err).Msg("failed to get available count")
+		log.Err(err).Msg("failed to update books available count")
 		if errors.Is(err, errRecordNotFound) {
 			return c.JSON(http.StatusNotFound, echo.Map{
 				"message": "record not found",
 			})
 		}
+		if errors.Is(err, errNotEnoughCopies) {
+			return c.JSON(http.StatusConflict, echo.Map{
+				"message": errNotEnoughCopies.Error(),
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"message": "failed to get available count",
+			"message": "failed to update books available count",
 		})
 	}
 
-	if actualCount+countDiff < 0 {
+	return c.NoContent(http.StatusOK)
+}
+
+type copyResp struct {
+	CopyUid        string  `json:"copyUid"`
+	LibraryUid     string  `json:"libraryUid"`
+	BookUid        string  `json:"bookUid"`
+	Condition      string  `json:"condition"`
+	Status         string  `json:"status"`
+	ReservationUid *string `json:"reservationUid"`
+}
+
+func newCopyResp(v bookCopy) copyResp {
+	return copyResp{
+		CopyUid:        v.CopyUid,
+		LibraryUid:     v.LibraryUid,
+		BookUid:        v.BookUid,
+		Condition:      v.Condition,
+		Status:         v.Status,
+		ReservationUid: v.ReservationUid,
+	}
+}
+
+func (h *handler) GetBookCopies(c echo.Context) error {
+	libraryUid := c.Param("libraryuid")
+	bookUid := c.Param("bookuid")
+	if libraryUid == "" || bookUid == "" {
 		return c.JSON(http.StatusBadRequest, echo.Map{
-			"message": "count is wrong",
+			"message": "uid is wrong",
 		})
 	}
 
-	err = h.storage.UpdateBooksAvailableCount(c.Request().Context(), libraryUid, bookUid, actualCount+countDiff)
+	copies, err := h.storage.GetBookCopies(c.Request().Context(), libraryUid, bookUid)
 	if err != nil {
-		log.Err(err).Msg("failed to update books available count")
+		log.Err(err).Msg("failed to get book copies")
 		if errors.Is(err, errRecordNotFound) {
 			return c.JSON(http.StatusNotFound, echo.Map{
 				"message": "record not found",
 			})
 		}
 		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"message": "failed to update books available count",
+			"message": "failed to get book copies",
+		})
+	}
+
+	type response struct {
+		Data []copyResp `json:"data"`
+	}
+
+	items := make([]copyResp, 0, len(copies))
+	for _, v := range copies {
+		items = append(items, newCopyResp(v))
+	}
+
+	return c.JSON(http.StatusOK, response{Data: items})
+}
+
+func (h *handler) GetCopyByReservation(c echo.Context) error {
+	reservationUid := c.Param("reservationuid")
+	if reservationUid == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+
+	bookCopy, err := h.storage.GetCopyByReservation(c.Request().Context(), reservationUid)
+	if err != nil {
+		log.Err(err).Msg("failed to get book copy")
+		if errors.Is(err, errCopyNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": "book copy not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to get book copy",
+		})
+	}
+
+	return c.JSON(http.StatusOK, newCopyResp(bookCopy))
+}
+
+func (h *handler) UpdateCopyCondition(c echo.Context) error {
+	copyUid := c.Param("copyuid")
+	if copyUid == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "uid is wrong",
+		})
+	}
+
+	condition := c.QueryParam("condition")
+	if _, ok := bookConditions[condition]; !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "condition is wrong",
+		})
+	}
+
+	err := h.storage.UpdateCopyCondition(c.Request().Context(), copyUid, condition)
+	if err != nil {
+		log.Err(err).Msg("failed to update book copy condition")
+		if errors.Is(err, errCopyNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": "book copy not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to update book copy condition",
 		})
 	}
 
 	return c.NoContent(http.StatusOK)
 }
+
+// checkOperation возвращает errOperationApplied, если op уже применена, и errOperationConflict,
+// если ее идентификатор использован для другого изменения
+func (h *handler) checkOperation(ctx context.Context, op operation) error {
+	applied, err := h.storage.GetOperation(ctx, op.ID)
+	if errors.Is(err, errOperationNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !applied.sameAs(op) {
+		return errOperationConflict
+	}
+	return errOperationApplied
+}
+
+func operationResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errOperationApplied):
+		log.Info().Msg("operation already applied")
+		return c.NoContent(http.StatusOK)
+	case errors.Is(err, errOperationConflict):
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": errOperationConflict.Error(),
+		})
+	default:
+		log.Err(err).Msg("failed to get operation")
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "failed to get operation",
+		})
+	}
+}
@@ -34,19 +34,103 @@ func (m *Mockstorage) EXPECT() *MockstorageMockRecorder {
 	return m.recorder
 }
 
-// GetBooksAvailableCount mocks base method.
-func (m *Mockstorage) GetBooksAvailableCount(ctx context.Context, libraryUid, bookUid string) (int, error) {
+// CreateBook mocks base method.
+func (m *Mockstorage) CreateBook(ctx context.Context, b book) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBooksAvailableCount", ctx, libraryUid, bookUid)
-	ret0, _ := ret[0].(int)
+	ret := m.ctrl.Call(m, "CreateBook", ctx, b)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateBook indicates an expected call of CreateBook.
+func (mr *MockstorageMockRecorder) CreateBook(ctx, b interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBook", reflect.TypeOf((*Mockstorage)(nil).CreateBook), ctx, b)
+}
+
+// CreateLibrary mocks base method.
+func (m *Mockstorage) CreateLibrary(ctx context.Context, l library) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateLibrary", ctx, l)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateLibrary indicates an expected call of CreateLibrary.
+func (mr *MockstorageMockRecorder) CreateLibrary(ctx, l interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLibrary", reflect.TypeOf((*Mockstorage)(nil).CreateLibrary), ctx, l)
+}
+
+// DeleteBook mocks base method.
+func (m *Mockstorage) DeleteBook(ctx context.Context, bookUid string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteBook", ctx, bookUid)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteBook indicates an expected call of DeleteBook.
+func (mr *MockstorageMockRecorder) DeleteBook(ctx, bookUid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBook", reflect.TypeOf((*Mockstorage)(nil).DeleteBook), ctx, bookUid)
+}
+
+// DeleteLibrary mocks base method.
+func (m *Mockstorage) DeleteLibrary(ctx context.Context, libraryUid string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteLibrary", ctx, libraryUid)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteLibrary indicates an expected call of DeleteLibrary.
+func (mr *MockstorageMockRecorder) DeleteLibrary(ctx, libraryUid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteLibrary", reflect.TypeOf((*Mockstorage)(nil).DeleteLibrary), ctx, libraryUid)
+}
+
+// DeleteLibraryBook mocks base method.
+func (m *Mockstorage) DeleteLibraryBook(ctx context.Context, libraryUid, bookUid string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteLibraryBook", ctx, libraryUid, bookUid)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteLibraryBook indicates an expected call of DeleteLibraryBook.
+func (mr *MockstorageMockRecorder) DeleteLibraryBook(ctx, libraryUid, bookUid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteLibraryBook", reflect.TypeOf((*Mockstorage)(nil).DeleteLibraryBook), ctx, libraryUid, bookUid)
+}
+
+// ExportCatalog mocks base method.
+func (m *Mockstorage) ExportCatalog(ctx context.Context, fn func(row catalogRow) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportCatalog", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportCatalog indicates an expected call of ExportCatalog.
+func (mr *MockstorageMockRecorder) ExportCatalog(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportCatalog", reflect.TypeOf((*Mockstorage)(nil).ExportCatalog), ctx, fn)
+}
+
+// GetBookCopies mocks base method.
+func (m *Mockstorage) GetBookCopies(ctx context.Context, libraryUid, bookUid string) ([]bookCopy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBookCopies", ctx, libraryUid, bookUid)
+	ret0, _ := ret[0].([]bookCopy)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetBooksAvailableCount indicates an expected call of GetBooksAvailableCount.
-func (mr *MockstorageMockRecorder) GetBooksAvailableCount(ctx, libraryUid, bookUid interface{}) *gomock.Call {
+// GetBookCopies indicates an expected call of GetBookCopies.
+func (mr *MockstorageMockRecorder) GetBookCopies(ctx, libraryUid, bookUid interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBooksAvailableCount", reflect.TypeOf((*Mockstorage)(nil).GetBooksAvailableCount), ctx, libraryUid, bookUid)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBookCopies", reflect.TypeOf((*Mockstorage)(nil).GetBookCopies), ctx, libraryUid, bookUid)
 }
 
 // GetBooksByLibrary mocks base method.
@@ -79,6 +163,21 @@ func (mr *MockstorageMockRecorder) GetBooksByUids(ctx, uids interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBooksByUids", reflect.TypeOf((*Mockstorage)(nil).GetBooksByUids), ctx, uids)
 }
 
+// GetCopyByReservation mocks base method.
+func (m *Mockstorage) GetCopyByReservation(ctx context.Context, reservationUid string) (bookCopy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCopyByReservation", ctx, reservationUid)
+	ret0, _ := ret[0].(bookCopy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCopyByReservation indicates an expected call of GetCopyByReservation.
+func (mr *MockstorageMockRecorder) GetCopyByReservation(ctx, reservationUid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCopyByReservation", reflect.TypeOf((*Mockstorage)(nil).GetCopyByReservation), ctx, reservationUid)
+}
+
 // GetLibraries mocks base method.
 func (m *Mockstorage) GetLibraries(ctx context.Context, city string, offset, limit int) ([]library, error) {
 	m.ctrl.T.Helper()
@@ -109,16 +208,120 @@ func (mr *MockstorageMockRecorder) GetLibrariesByUids(ctx, uids interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLibrariesByUids", reflect.TypeOf((*Mockstorage)(nil).GetLibrariesByUids), ctx, uids)
 }
 
-// UpdateBooksAvailableCount mocks base method.
-func (m *Mockstorage) UpdateBooksAvailableCount(ctx context.Context, libraryUid, bookUid string, count int) error {
+// GetOperation mocks base method.
+func (m *Mockstorage) GetOperation(ctx context.Context, operationId string) (operation, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateBooksAvailableCount", ctx, libraryUid, bookUid, count)
+	ret := m.ctrl.Call(m, "GetOperation", ctx, operationId)
+	ret0, _ := ret[0].(operation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOperation indicates an expected call of GetOperation.
+func (mr *MockstorageMockRecorder) GetOperation(ctx, operationId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOperation", reflect.TypeOf((*Mockstorage)(nil).GetOperation), ctx, operationId)
+}
+
+// ImportCatalog mocks base method.
+func (m *Mockstorage) ImportCatalog(ctx context.Context, rows []catalogRow, dryRun bool) ([]importResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportCatalog", ctx, rows, dryRun)
+	ret0, _ := ret[0].([]importResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImportCatalog indicates an expected call of ImportCatalog.
+func (mr *MockstorageMockRecorder) ImportCatalog(ctx, rows, dryRun interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportCatalog", reflect.TypeOf((*Mockstorage)(nil).ImportCatalog), ctx, rows, dryRun)
+}
+
+// SearchBooks mocks base method.
+func (m *Mockstorage) SearchBooks(ctx context.Context, filter bookSearchFilter, offset, limit int) ([]foundBook, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchBooks", ctx, filter, offset, limit)
+	ret0, _ := ret[0].([]foundBook)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// SearchBooks indicates an expected call of SearchBooks.
+func (mr *MockstorageMockRecorder) SearchBooks(ctx, filter, offset, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchBooks", reflect.TypeOf((*Mockstorage)(nil).SearchBooks), ctx, filter, offset, limit)
+}
+
+// SetLibraryBookStock mocks base method.
+func (m *Mockstorage) SetLibraryBookStock(ctx context.Context, libraryUid, bookUid string, availableCount int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLibraryBookStock", ctx, libraryUid, bookUid, availableCount)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetLibraryBookStock indicates an expected call of SetLibraryBookStock.
+func (mr *MockstorageMockRecorder) SetLibraryBookStock(ctx, libraryUid, bookUid, availableCount interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLibraryBookStock", reflect.TypeOf((*Mockstorage)(nil).SetLibraryBookStock), ctx, libraryUid, bookUid, availableCount)
+}
+
+// UpdateBook mocks base method.
+func (m *Mockstorage) UpdateBook(ctx context.Context, b book) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBook", ctx, b)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// UpdateBook indicates an expected call of UpdateBook.
+func (mr *MockstorageMockRecorder) UpdateBook(ctx, b interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBook", reflect.TypeOf((*Mockstorage)(nil).UpdateBook), ctx, b)
+}
+
+// UpdateBooksAvailableCount mocks base method.
+func (m *Mockstorage) UpdateBooksAvailableCount(ctx context.Context, libraryUid, bookUid string, countDiff int, reservationUid string, returned bool, op *operation) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBooksAvailableCount", ctx, libraryUid, bookUid, countDiff, reservationUid, returned, op)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // UpdateBooksAvailableCount indicates an expected call of UpdateBooksAvailableCount.
-func (mr *MockstorageMockRecorder) UpdateBooksAvailableCount(ctx, libraryUid, bookUid, count interface{}) *gomock.Call {
+func (mr *MockstorageMockRecorder) UpdateBooksAvailableCount(ctx, libraryUid, bookUid, countDiff, reservationUid, returned, op interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBooksAvailableCount", reflect.TypeOf((*Mockstorage)(nil).UpdateBooksAvailableCount), ctx, libraryUid, bookUid, countDiff, reservationUid, returned, op)
+}
+
+// UpdateCopyCondition mocks base method.
+func (m *Mockstorage) UpdateCopyCondition(ctx context.Context, copyUid, condition string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateCopyCondition", ctx, copyUid, condition)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateCopyCondition indicates an expected call of UpdateCopyCondition.
+func (mr *MockstorageMockRecorder) UpdateCopyCondition(ctx, copyUid, condition interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCopyCondition", reflect.TypeOf((*Mockstorage)(nil).UpdateCopyCondition), ctx, copyUid, condition)
+}
+
+// UpdateLibrary mocks base method.
+func (m *Mockstorage) UpdateLibrary(ctx context.Context, l library) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateLibrary", ctx, l)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateLibrary indicates an expected call of UpdateLibrary.
+func (mr *MockstorageMockRecorder) UpdateLibrary(ctx, l interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBooksAvailableCount", reflect.TypeOf((*Mockstorage)(nil).UpdateBooksAvailableCount), ctx, libraryUid, bookUid, count)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLibrary", reflect.TypeOf((*Mockstorage)(nil).UpdateLibrary), ctx, l)
 }
@@ -27,6 +27,9 @@ func Test_UpdateBooksAvailableCount(t *testing.T) {
 		libraryUid       string
 		bookUid          string
 		countDiff        string
+		operationId      string
+		reservationUid   string
+		returned         string
 		expectedHTTPCode int
 	}
 
@@ -75,29 +78,29 @@ func Test_UpdateBooksAvailableCount(t *testing.T) {
 			},
 		},
 		{
-			name: "http-code 400: wrong countDiff - negative available count",
+			name: "http-code 409: not enough copies",
 			fields: fields{
-				expectedHTTPCode: http.StatusBadRequest,
+				expectedHTTPCode: http.StatusConflict,
 				libraryUid:       "test",
 				bookUid:          "test",
 				countDiff:        "-2",
 			},
 
 			Prepare: func(fields *handlerTestFields) {
-				fields.storage.EXPECT().GetBooksAvailableCount(gomock.Any(), "test", "test").Return(1, nil)
+				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", -2, "", false, nil).Return(0, errNotEnoughCopies)
 			},
 		},
 		{
-			name: "http-code 500: GetBooksAvailableCount error",
+			name: "http-code 404: record not found",
 			fields: fields{
-				expectedHTTPCode: http.StatusInternalServerError,
+				expectedHTTPCode: http.StatusNotFound,
 				libraryUid:       "test",
 				bookUid:          "test",
 				countDiff:        "-1",
 			},
 
 			Prepare: func(fields *handlerTestFields) {
-				fields.storage.EXPECT().GetBooksAvailableCount(gomock.Any(), "test", "test").Return(0, errors.New(""))
+				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", -1, "", false, nil).Return(0, errRecordNotFound)
 			},
 		},
 		{
@@ -110,8 +113,7 @@ func Test_UpdateBooksAvailableCount(t *testing.T) {
 			},
 
 			Prepare: func(fields *handlerTestFields) {
-				fields.storage.EXPECT().GetBooksAvailableCount(gomock.Any(), "test", "test").Return(1, nil)
-				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", 0).Return(errors.New(""))
+				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", -1, "", false, nil).Return(0, errors.New(""))
 			},
 		},
 		{
@@ -124,8 +126,134 @@ func Test_UpdateBooksAvailableCount(t *testing.T) {
 			},
 
 			Prepare: func(fields *handlerTestFields) {
-				fields.storage.EXPECT().GetBooksAvailableCount(gomock.Any(), "test", "test").Return(1, nil)
-				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", 0).Return(nil)
+				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", -1, "", false, nil).Return(0, nil)
+			},
+		},
+		{
+			name: "http-code 200: copy bound to reservation",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "-1",
+				reservationUid:   "f7cdc58f-2caf-4b15-9727-f89dcc629b27",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", -1, "f7cdc58f-2caf-4b15-9727-f89dcc629b27", false, nil).Return(0, nil)
+			},
+		},
+		{
+			name: "http-code 200: book returned",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "1",
+				reservationUid:   "f7cdc58f-2caf-4b15-9727-f89dcc629b27",
+				returned:         "true",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", 1, "f7cdc58f-2caf-4b15-9727-f89dcc629b27", true, nil).Return(0, nil)
+			},
+		},
+		{
+			name: "http-code 400: wrong returned",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "1",
+				returned:         "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: wrong reservationUid",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "-1",
+				reservationUid:   "reservation",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 200: success with operation",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "-1",
+				operationId:      "op",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{}, errOperationNotFound)
+				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", -1, "", false, &operation{ID: "op", LibraryUid: "test", BookUid: "test", CountDiff: -1}).Return(0, nil)
+			},
+		},
+		{
+			name: "http-code 200: operation already applied",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "-1",
+				operationId:      "op",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{ID: "op", LibraryUid: "TEST", BookUid: "test", CountDiff: -1}, nil)
+			},
+		},
+		{
+			name: "http-code 200: operation applied concurrently",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "-1",
+				operationId:      "op",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{}, errOperationNotFound)
+				fields.storage.EXPECT().UpdateBooksAvailableCount(gomock.Any(), "test", "test", -1, "", false, &operation{ID: "op", LibraryUid: "test", BookUid: "test", CountDiff: -1}).Return(0, errOperationApplied)
+			},
+		},
+		{
+			name: "http-code 409: operation id used for another update",
+			fields: fields{
+				expectedHTTPCode: http.StatusConflict,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "-1",
+				operationId:      "op",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{ID: "op", LibraryUid: "test", BookUid: "test", CountDiff: 1}, nil)
+			},
+		},
+		{
+			name: "http-code 500: GetOperation error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				libraryUid:       "test",
+				bookUid:          "test",
+				countDiff:        "-1",
+				operationId:      "op",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{}, errors.New(""))
 			},
 		},
 	}
@@ -139,7 +267,7 @@ func Test_UpdateBooksAvailableCount(t *testing.T) {
 
 			h := &handler{storage: testFields.storage}
 
-			req := httptest.NewRequest(http.MethodPut, "/test?countDiff="+tt.fields.countDiff, nil)
+			req := httptest.NewRequest(http.MethodPut, "/test?countDiff="+tt.fields.countDiff+"&operationId="+tt.fields.operationId+"&reservationUid="+tt.fields.reservationUid+"&returned="+tt.fields.returned, nil)
 			rec := httptest.NewRecorder()
 			c := e.NewContext(req, rec)
 			c.SetParamNames("libraryuid", "bookuid")
@@ -152,3 +280,397 @@ func Test_UpdateBooksAvailableCount(t *testing.T) {
 		})
 	}
 }
+
+func Test_UpdateCopyCondition(t *testing.T) {
+	type fields struct {
+		copyUid          string
+		condition        string
+		expectedHTTPCode int
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong copyuid",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				copyUid:          "",
+				condition:        "BAD",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: wrong condition",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				copyUid:          "test",
+				condition:        "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 404: copy not found",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+				copyUid:          "test",
+				condition:        "BAD",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateCopyCondition(gomock.Any(), "test", "BAD").Return(errCopyNotFound)
+			},
+		},
+		{
+			name: "http-code 500: UpdateCopyCondition error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				copyUid:          "test",
+				condition:        "BAD",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateCopyCondition(gomock.Any(), "test", "BAD").Return(errors.New(""))
+			},
+		},
+		{
+			name: "http-code 200: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				copyUid:          "test",
+				condition:        "GOOD",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateCopyCondition(gomock.Any(), "test", "GOOD").Return(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodPut, "/test?condition="+tt.fields.condition, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("copyuid")
+			c.SetParamValues(tt.fields.copyUid)
+
+			err := h.UpdateCopyCondition(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+		})
+	}
+}
+
+func Test_GetCopyByReservation(t *testing.T) {
+	type fields struct {
+		reservationUid       string
+		expectedHTTPCode     int
+		expectedResponseBody string
+	}
+
+	e := echo.New()
+
+	reservationUid := "reservation"
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong reservationuid",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 404: copy not found",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+				reservationUid:   "reservation",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetCopyByReservation(gomock.Any(), "reservation").Return(bookCopy{}, errCopyNotFound)
+			},
+		},
+		{
+			name: "http-code 500: GetCopyByReservation error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				reservationUid:   "reservation",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetCopyByReservation(gomock.Any(), "reservation").Return(bookCopy{}, errors.New(""))
+			},
+		},
+		{
+			name: "http-code 200: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				reservationUid:   "reservation",
+				expectedResponseBody: `{"copyUid":"copy","libraryUid":"library","bookUid":"book","condition":"GOOD","status":"RENTED","reservationUid":"reservation"}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetCopyByReservation(gomock.Any(), "reservation").Return(bookCopy{
+					CopyUid:        "copy",
+					LibraryUid:     "library",
+					BookUid:        "book",
+					Condition:      "GOOD",
+					Status:         copyStatusRented,
+					ReservationUid: &reservationUid,
+				}, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("reservationuid")
+			c.SetParamValues(tt.fields.reservationUid)
+
+			err := h.GetCopyByReservation(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+			if tt.fields.expectedHTTPCode == http.StatusOK {
+				require.Equal(t, tt.fields.expectedResponseBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_GetBookCopies(t *testing.T) {
+	type fields struct {
+		libraryUid       string
+		bookUid          string
+		expectedHTTPCode int
+	}
+
+	e := echo.New()
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong bookuid",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				libraryUid:       "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 404: record not found",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+				libraryUid:       "test",
+				bookUid:          "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetBookCopies(gomock.Any(), "test", "test").Return(nil, errRecordNotFound)
+			},
+		},
+		{
+			name: "http-code 200: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				libraryUid:       "test",
+				bookUid:          "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetBookCopies(gomock.Any(), "test", "test").Return([]bookCopy{{CopyUid: "copy", Status: copyStatusAvailable}}, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("libraryuid", "bookuid")
+			c.SetParamValues(tt.fields.libraryUid, tt.fields.bookUid)
+
+			err := h.GetBookCopies(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+		})
+	}
+}
+
+func Test_SearchBooks(t *testing.T) {
+	type fields struct {
+		query            string
+		expectedHTTPCode int
+		expectedBody     string
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: empty query",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				query:            "query=%20&page=1&size=10",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: wrong page",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				query:            "query=test&page=0&size=10",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: wrong available",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				query:            "query=test&page=1&size=10&available=test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 500: SearchBooks error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				query:            "query=test&page=1&size=10",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().SearchBooks(gomock.Any(), bookSearchFilter{Query: "test"}, 0, 10).Return(nil, 0, errors.New(""))
+			},
+		},
+		{
+			name: "http-code 200: nothing found",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				query:            "query=test&page=1&size=10",
+				expectedBody:     `{"page":1,"pageSize":10,"totalElements":0,"items":[]}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().SearchBooks(gomock.Any(), bookSearchFilter{Query: "test"}, 0, 10).Return([]foundBook{}, 0, nil)
+			},
+		},
+		{
+			name: "http-code 200: page past the end",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				query:            "query=test&page=3&size=10",
+				expectedBody:     `{"page":3,"pageSize":10,"totalElements":12,"items":[]}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().SearchBooks(gomock.Any(), bookSearchFilter{Query: "test"}, 20, 10).Return([]foundBook{}, 12, nil)
+			},
+		},
+		{
+			name: "http-code 200: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				query:            "query=%D1%81%D1%82%D1%80%D0%B0%D1%83%D1%81%D1%82%D1%80%D1%83%D0%BF&city=%D0%9C%D0%BE%D1%81%D0%BA%D0%B2%D0%B0&available=true&page=2&size=1",
+				expectedBody: `{"page":2,"pageSize":1,"totalElements":2,"items":[{"bookUid":"book","name":"Краткий курс C++","author":"Бьерн Страуструп","genre":"Научная фантастика","condition":"EXCELLENT",` +
+					`"libraries":[{"libraryUid":"library","name":"Библиотека имени 7 Непьющих","address":"2-я Бауманская ул., д.5, стр.1","city":"Москва","availableCount":1}]}]}`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				filter := bookSearchFilter{Query: "страуструп", City: "Москва", Available: true}
+				fields.storage.EXPECT().SearchBooks(gomock.Any(), filter, 1, 1).Return([]foundBook{{
+					ID:        1,
+					BookUid:   "book",
+					Name:      "Краткий курс C++",
+					Author:    "Бьерн Страуструп",
+					Genre:     "Научная фантастика",
+					Condition: "EXCELLENT",
+					Libraries: []bookLibrary{{BookID: 1, LibraryUid: "library", Name: "Библиотека имени 7 Непьющих", Address: "2-я Бауманская ул., д.5, стр.1", City: "Москва", AvailableCount: 1}},
+				}}, 2, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodGet, "/test?"+tt.fields.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			err := h.SearchBooks(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+			if tt.fields.expectedBody != "" {
+				require.JSONEq(t, tt.fields.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package library
 
+import "strings"
+
 type library struct {
 	ID         int    `db:"id"`
 	LibraryUid string `db:"library_uid"`
@@ -8,6 +10,53 @@ type library struct {
 	City       string `db:"city"`
 }
 
+// operation - примененное изменение числа доступных книг, повтор с тем же ID не применяется
+type operation struct {
+	ID         string `db:"operation_id"`
+	LibraryUid string `db:"library_uid"`
+	BookUid    string `db:"book_uid"`
+	CountDiff  int    `db:"count_diff"`
+}
+
+// sameAs сравнивает операции без учета регистра uid: postgres возвращает uuid в нижнем регистре
+func (o operation) sameAs(other operation) bool {
+	return o.ID == other.ID &&
+		strings.EqualFold(o.LibraryUid, other.LibraryUid) &&
+		strings.EqualFold(o.BookUid, other.BookUid) &&
+		o.CountDiff == other.CountDiff
+}
+
+// bookConditions - допустимые состояния книги
+var bookConditions = map[string]struct{}{
+	"EXCELLENT": {},
+	"GOOD":      {},
+	"BAD":       {},
+}
+
+// статусы экземпляра книги
+const (
+	copyStatusAvailable = "AVAILABLE"
+	copyStatusRented    = "RENTED"
+)
+
+// bookCopy - физический экземпляр книги в библиотеке. ReservationUid - бронь, по которой экземпляр выдан.
+type bookCopy struct {
+	ID             int     `db:"id"`
+	CopyUid        string  `db:"copy_uid"`
+	LibraryUid     string  `db:"library_uid"`
+	BookUid        string  `db:"book_uid"`
+	Condition      string  `db:"condition"`
+	Status         string  `db:"status"`
+	ReservationUid *string `db:"reservation_uid"`
+}
+
+// libraryBook - идентификаторы книги в библиотеке. Deleted - удалена библиотека, книга или сама запись.
+type libraryBook struct {
+	LibraryID int  `db:"library_id"`
+	BookID    int  `db:"book_id"`
+	Deleted   bool `db:"deleted"`
+}
+
 type book struct {
 	ID             int    `db:"id"`
 	BookUid        string `db:"book_uid"`
@@ -17,3 +66,64 @@ type book struct {
 	Condition      string `db:"condition"`
 	AvailableCount int    `db:"available_count"`
 }
+
+// catalogRow - строка импорта и экспорта каталога: книга и, если задана библиотека, число ее доступных экземпляров.
+// Книга ищется по BookUid, а без него - по Isbn.
+type catalogRow struct {
+	BookUid        string `json:"bookUid" db:"book_uid" validate:"omitempty,uuid"`
+	Isbn           string `json:"isbn" db:"isbn" validate:"required_without=BookUid,max=17"`
+	Name           string `json:"name" db:"name" validate:"required,max=255"`
+	Author         string `json:"author" db:"author" validate:"max=255"`
+	Genre          string `json:"genre" db:"genre" validate:"max=255"`
+	Condition      string `json:"condition" db:"condition" validate:"omitempty,oneof=EXCELLENT GOOD BAD"`
+	LibraryUid     string `json:"libraryUid" db:"library_uid" validate:"omitempty,uuid"`
+	AvailableCount *int   `json:"availableCount" db:"available_count" validate:"required_with=LibraryUid,omitempty,min=0"`
+}
+
+// catalogColumns - колонки CSV каталога в порядке экспорта
+var catalogColumns = []string{"bookUid", "isbn", "name", "author", "genre", "condition", "libraryUid", "availableCount"}
+
+// результаты импорта строки каталога
+const (
+	importStatusCreated = "CREATED"
+	importStatusUpdated = "UPDATED"
+	importStatusError   = "ERROR"
+)
+
+// importResult - результат импорта строки каталога, Row - номер строки с единицы
+type importResult struct {
+	Row        int    `json:"row"`
+	BookUid    string `json:"bookUid,omitempty"`
+	LibraryUid string `json:"libraryUid,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// bookSearchFilter - условия поиска книг: Query - полнотекстовый запрос по названию, автору и жанру,
+// City и Available ограничивают библиотеки, в которых ищется книга
+type bookSearchFilter struct {
+	Query     string
+	City      string
+	Available bool
+}
+
+// bookLibrary - библиотека, в которой есть книга BookID, с числом доступных экземпляров
+type bookLibrary struct {
+	BookID         int    `db:"book_id"`
+	LibraryUid     string `db:"library_uid"`
+	Name           string `db:"name"`
+	Address        string `db:"address"`
+	City           string `db:"city"`
+	AvailableCount int    `db:"available_count"`
+}
+
+// foundBook - найденная книга и библиотеки, подходящие под условия поиска
+type foundBook struct {
+	ID        int           `db:"id"`
+	BookUid   string        `db:"book_uid"`
+	Name      string        `db:"name"`
+	Author    string        `db:"author"`
+	Genre     string        `db:"genre"`
+	Condition string        `db:"condition"`
+	Libraries []bookLibrary `db:"-"`
+}
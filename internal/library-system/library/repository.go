@@ -2,16 +2,24 @@ package library
 
 import (
 	"context"
+	"database/sql"
 	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"time"
 )
 
 const (
 	defaultTimeout = 5 * time.Second
+	// catalogTimeout - таймаут импорта и экспорта каталога целиком
+	catalogTimeout = time.Minute
 )
 
+// availableCopiesColumn - число доступных экземпляров книги при соединении с book_copies c
+const availableCopiesColumn = "count(c.id) FILTER (WHERE c.status = '" + copyStatusAvailable + "')"
+
 type repository struct {
 	conn *sqlx.DB
 }
@@ -24,7 +32,7 @@ func (r *repository) GetLibraries(ctx context.Context, city string, offset, limi
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 	builder := psql.Select("id", "library_uid", "name", "address", "city").
 		From("library").
-		Where(sq.Eq{"city": city}).Limit(uint64(limit)).Offset(uint64(offset))
+		Where(sq.Eq{"city": city, "deleted_at": nil}).Limit(uint64(limit)).Offset(uint64(offset))
 
 	query, args, err := builder.ToSql()
 	if err != nil {
@@ -50,14 +58,18 @@ func (r *repository) GetLibraries(ctx context.Context, city string, offset, limi
 
 func (r *repository) GetBooksByLibrary(ctx context.Context, libraryUid string, offset, limit int, showAll bool) ([]book, error) {
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	builder := psql.Select("b.id", "b.book_uid", "b.name", "b.author", "b.genre", "b.condition", "lb.available_count").
+	builder := psql.Select("b.id", "b.book_uid", "b.name", "b.author", "b.genre", "b.condition", availableCopiesColumn+" AS available_count").
 		From("books b").
 		Join("library_books lb ON lb.book_id = b.id").
 		Join("library l ON lb.library_id = l.id").
-		Where(sq.Eq{"l.library_uid": libraryUid}).Limit(uint64(limit)).Offset(uint64(offset))
+		LeftJoin("book_copies c ON c.library_id = lb.library_id AND c.book_id = lb.book_id").
+		Where(sq.Eq{"l.library_uid": libraryUid, "l.deleted_at": nil, "b.deleted_at": nil, "lb.deleted_at": nil}).
+		GroupBy("b.id").
+		OrderBy("b.id").
+		Limit(uint64(limit)).Offset(uint64(offset))
 
 	if !showAll {
-		builder = builder.Where(sq.Gt{"lb.available_count": 0})
+		builder = builder.Having(availableCopiesColumn + " > 0")
 	}
 	query, args, err := builder.ToSql()
 
@@ -81,31 +93,6 @@ func (r *repository) GetBooksByLibrary(ctx context.Context, libraryUid string, o
 	return books, nil
 }
 
-func (r *repository) GetBooksAvailableCount(ctx context.Context, libraryUid, bookUid string) (int, error) {
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	builder := psql.Select("lb.available_count").
-		From("library_books lb").
-		Join("books b ON lb.book_id = b.id").
-		Join("library l ON lb.library_id = l.id").
-		Where(sq.Eq{"l.library_uid": libraryUid, "b.book_uid": bookUid})
-
-	query, args, err := builder.ToSql()
-	if err != nil {
-		return 0, errors.Wrap(err, "failed to build query")
-	}
-
-	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
-	defer cancel()
-
-	var count int
-	err = r.conn.GetContext(ctx, &count, query, args...)
-	if err != nil {
-		return 0, errors.Wrap(err, "failed to execute query")
-	}
-
-	return count, nil
-}
-
 func (r *repository) GetBooksByUids(ctx context.Context, uids []string) ([]book, error) {
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 	builder := psql.Select("id", "book_uid", "name", "author", "genre", "condition").
@@ -160,23 +147,513 @@ func (r *repository) GetLibrariesByUids(ctx context.Context, uids []string) ([]l
 	return libraries, nil
 }
 
-func (r *repository) UpdateBooksAvailableCount(ctx context.Context, libraryUid, bookUid string, count int) error {
+func (r *repository) UpdateCopyCondition(ctx context.Context, copyUid, condition string) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Update("book_copies").
+		Set("condition", condition).
+		Where(sq.Eq{"copy_uid": copyUid})
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "failed to build query")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	res, err := r.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute query")
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to get rows affected")
+	}
+	if rowsAffected == 0 {
+		return errCopyNotFound
+	}
+
+	return nil
+}
+
+func (r *repository) GetBookCopies(ctx context.Context, libraryUid, bookUid string) ([]bookCopy, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	_, err := r.getLibraryBook(ctx, r.conn, libraryUid, bookUid)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := selectCopies().Where(sq.Eq{"l.library_uid": libraryUid, "b.book_uid": bookUid}).OrderBy("c.id")
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build query")
+	}
+
+	copies := make([]bookCopy, 0)
+	err = r.conn.SelectContext(ctx, &copies, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute query")
+	}
+
+	return copies, nil
+}
+
+func (r *repository) GetCopyByReservation(ctx context.Context, reservationUid string) (bookCopy, error) {
+	builder := selectCopies().Where(sq.Eq{"c.reservation_uid": reservationUid})
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return bookCopy{}, errors.Wrap(err, "failed to build query")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	res := bookCopy{}
+	err = r.conn.GetContext(ctx, &res, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return bookCopy{}, errCopyNotFound
+		}
+		return bookCopy{}, errors.Wrap(err, "failed to execute query")
+	}
+
+	return res, nil
+}
+
+func selectCopies() sq.SelectBuilder {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	return psql.Select("c.id", "c.copy_uid", "l.library_uid", "b.book_uid", "c.condition", "c.status", "c.reservation_uid").
+		From("book_copies c").
+		Join("books b ON c.book_id = b.id").
+		Join("library l ON c.library_id = l.id")
+}
+
+// UpdateBooksAvailableCount атомарно изменяет число доступных экземпляров книги на countDiff и возвращает
+// новое значение. При уменьшении выдаются доступные экземпляры, они привязываются к брони reservationUid;
+// если доступных не хватает, изменение не применяется (errNotEnoughCopies). При увеличении освобождаются
+// экземпляры, выданные по reservationUid. Если их меньше, то при возврате книги читателем (returned)
+// недостающие экземпляры добавляются: книга выдана до учета экземпляров, и выданного экземпляра у нее нет.
+// Остальные увеличения без выданного экземпляра (например, компенсация невыполненной выдачи) число не меняют.
+// Если передана операция op, она записывается в applied_operations в той же транзакции,
+// поэтому повтор операции не применяется.
+func (r *repository) UpdateBooksAvailableCount(ctx context.Context, libraryUid, bookUid string, countDiff int, reservationUid string, returned bool, op *operation) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	if op != nil {
+		err = r.insertOperation(ctx, tx, *op)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	lb, err := r.getLibraryBook(ctx, tx, libraryUid, bookUid)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case countDiff < 0 && lb.Deleted:
+		// удаленные библиотеку и книгу не выдают, но выданные ранее экземпляры принимают обратно
+		return 0, errors.Wrap(errRecordNotFound, "library book is deleted")
+	case countDiff < 0:
+		err = rentCopies(ctx, tx, lb, -countDiff, reservationUid)
+	case countDiff > 0:
+		var released int
+		released, err = releaseCopies(ctx, tx, lb, countDiff, reservationUid)
+		if err == nil && returned && released < countDiff {
+			err = addCopies(ctx, tx, lb, countDiff-released)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := r.getBooksAvailableCount(ctx, tx, libraryUid, bookUid)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to commit transaction")
+	}
+
+	return count, nil
+}
+
+// rentCopies выдает count доступных экземпляров по брони reservationUid. Экземпляры, заблокированные
+// конкурентными выдачами, пропускаются, поэтому один экземпляр не выдается дважды.
+func rentCopies(ctx context.Context, tx *sqlx.Tx, lb libraryBook, count int, reservationUid string) error {
 	query := `
-UPDATE library_books
-SET available_count = $1
-WHERE book_id = (
-    SELECT id FROM books WHERE book_uid = $2
-)
-AND library_id = (
-    SELECT id FROM library WHERE library_uid = $3
+UPDATE book_copies
+SET status = $1, reservation_uid = NULLIF($2, '')::uuid
+WHERE id IN (
+    SELECT id FROM book_copies
+    WHERE library_id = $3 AND book_id = $4 AND status = $5
+    ORDER BY id
+    LIMIT $6
+    FOR UPDATE SKIP LOCKED
 );
 `
-	args := []interface{}{count, bookUid, libraryUid}
+	res, err := tx.ExecContext(ctx, query, copyStatusRented, reservationUid, lb.LibraryID, lb.BookID, copyStatusAvailable, count)
+	if err != nil {
+		return errors.Wrap(err, "failed to rent copies")
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to get rows affected")
+	}
+	if rowsAffected < int64(count) {
+		return errNotEnoughCopies
+	}
+	return nil
+}
+
+// releaseCopies возвращает до count экземпляров, выданных по брони reservationUid (без брони, если она
+// не передана), и число возвращенных. Экземпляров, которые не выдавались, не появляется.
+func releaseCopies(ctx context.Context, tx *sqlx.Tx, lb libraryBook, count int, reservationUid string) (int, error) {
+	releaseQuery := `
+UPDATE book_copies
+SET status = $1, reservation_uid = NULL
+WHERE id IN (
+    SELECT id FROM book_copies
+    WHERE library_id = $2 AND book_id = $3 AND status = $4 AND reservation_uid IS NOT DISTINCT FROM NULLIF($5, '')::uuid
+    ORDER BY id
+    LIMIT $6
+    FOR UPDATE
+);
+`
+	res, err := tx.ExecContext(ctx, releaseQuery, copyStatusAvailable, lb.LibraryID, lb.BookID, copyStatusRented, reservationUid, count)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to release copies")
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get rows affected")
+	}
+	return int(rowsAffected), nil
+}
+
+// addCopies добавляет count доступных экземпляров в состоянии книги
+func addCopies(ctx context.Context, tx *sqlx.Tx, lb libraryBook, count int) error {
+	query := `
+INSERT INTO book_copies (book_id, library_id, condition)
+SELECT b.id, $1, COALESCE(b.condition, 'EXCELLENT')
+FROM books b
+CROSS JOIN generate_series(1, $2)
+WHERE b.id = $3;
+`
+	_, err := tx.ExecContext(ctx, query, lb.LibraryID, count, lb.BookID)
+	if err != nil {
+		return errors.Wrap(err, "failed to add copies")
+	}
+	return nil
+}
+
+func (r *repository) getLibraryBook(ctx context.Context, q sqlx.QueryerContext, libraryUid, bookUid string) (libraryBook, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Select("lb.library_id", "lb.book_id",
+		"(l.deleted_at IS NOT NULL OR b.deleted_at IS NOT NULL OR lb.deleted_at IS NOT NULL) AS deleted").
+		From("library_books lb").
+		Join("books b ON lb.book_id = b.id").
+		Join("library l ON lb.library_id = l.id").
+		Where(sq.Eq{"l.library_uid": libraryUid, "b.book_uid": bookUid})
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return libraryBook{}, errors.Wrap(err, "failed to build query")
+	}
+
+	res := libraryBook{}
+	err = sqlx.GetContext(ctx, q, &res, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return libraryBook{}, errors.Wrap(errRecordNotFound, "library book not found")
+		}
+		return libraryBook{}, errors.Wrap(err, "failed to execute query")
+	}
+
+	return res, nil
+}
+
+// getBooksAvailableCount возвращает число доступных экземпляров книги в библиотеке
+func (r *repository) getBooksAvailableCount(ctx context.Context, q sqlx.QueryerContext, libraryUid, bookUid string) (int, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Select(availableCopiesColumn).
+		From("library_books lb").
+		Join("books b ON lb.book_id = b.id").
+		Join("library l ON lb.library_id = l.id").
+		LeftJoin("book_copies c ON c.library_id = lb.library_id AND c.book_id = lb.book_id").
+		Where(sq.Eq{"l.library_uid": libraryUid, "b.book_uid": bookUid}).
+		GroupBy("lb.library_id", "lb.book_id")
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to build query")
+	}
+
+	var count int
+	err = sqlx.GetContext(ctx, q, &count, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.Wrap(errRecordNotFound, "library book not found")
+		}
+		return 0, errors.Wrap(err, "failed to execute query")
+	}
+
+	return count, nil
+}
+
+// insertOperation записывает операцию. Если операция с тем же ID уже записана (в том числе
+// конкурентной транзакцией), возвращает errOperationApplied или errOperationConflict.
+func (r *repository) insertOperation(ctx context.Context, tx *sqlx.Tx, op operation) error {
+	query := `
+INSERT INTO applied_operations (operation_id, library_uid, book_uid, count_diff)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (operation_id) DO NOTHING;
+`
+	res, err := tx.ExecContext(ctx, query, op.ID, op.LibraryUid, op.BookUid, op.CountDiff)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert operation")
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to get rows affected")
+	}
+	if rowsAffected != 0 {
+		return nil
+	}
+
+	applied, err := r.getOperation(ctx, tx, op.ID)
+	if err != nil {
+		return err
+	}
+	if !applied.sameAs(op) {
+		return errOperationConflict
+	}
+	return errOperationApplied
+}
+
+func (r *repository) GetOperation(ctx context.Context, operationId string) (operation, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	return r.getOperation(ctx, r.conn, operationId)
+}
+
+func (r *repository) getOperation(ctx context.Context, q sqlx.QueryerContext, operationId string) (operation, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Select("operation_id", "library_uid", "book_uid", "count_diff").
+		From("applied_operations").
+		Where(sq.Eq{"operation_id": operationId})
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return operation{}, errors.Wrap(err, "failed to build query")
+	}
+
+	res := operation{}
+	err = sqlx.GetContext(ctx, q, &res, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return operation{}, errOperationNotFound
+		}
+		return operation{}, errors.Wrap(err, "failed to execute query")
+	}
+
+	return res, nil
+}
+
+func (r *repository) CreateLibrary(ctx context.Context, l library) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Insert("library").
+		Columns("library_uid", "name", "city", "address").
+		Values(l.LibraryUid, l.Name, l.City, l.Address)
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "failed to build query")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	_, err = r.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute query")
+	}
+
+	return nil
+}
+
+func (r *repository) UpdateLibrary(ctx context.Context, l library) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Update("library").
+		Set("name", l.Name).
+		Set("city", l.City).
+		Set("address", l.Address).
+		Where(sq.Eq{"library_uid": l.LibraryUid, "deleted_at": nil})
+
+	return r.execUpdate(ctx, builder, errLibraryNotFound)
+}
+
+// DeleteLibrary помечает библиотеку удаленной, ее книги перестают выдаваться
+func (r *repository) DeleteLibrary(ctx context.Context, libraryUid string) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Update("library").
+		Set("deleted_at", sq.Expr("now()")).
+		Where(sq.Eq{"library_uid": libraryUid, "deleted_at": nil})
+
+	return r.execUpdate(ctx, builder, errLibraryNotFound)
+}
+
+func (r *repository) CreateBook(ctx context.Context, b book) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Insert("books").
+		Columns("book_uid", "name", "author", "genre", "condition").
+		Values(b.BookUid, b.Name, b.Author, b.Genre, b.Condition)
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "failed to build query")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	_, err = r.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute query")
+	}
+
+	return nil
+}
+
+// UpdateBook изменяет описание книги. Состояние книги - состояние новых экземпляров, уже учтенные не меняются.
+func (r *repository) UpdateBook(ctx context.Context, b book) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Update("books").
+		Set("name", b.Name).
+		Set("author", b.Author).
+		Set("genre", b.Genre).
+		Set("condition", b.Condition).
+		Where(sq.Eq{"book_uid": b.BookUid, "deleted_at": nil})
+
+	return r.execUpdate(ctx, builder, errBookNotFound)
+}
+
+// DeleteBook помечает книгу удаленной во всех библиотеках
+func (r *repository) DeleteBook(ctx context.Context, bookUid string) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	builder := psql.Update("books").
+		Set("deleted_at", sq.Expr("now()")).
+		Where(sq.Eq{"book_uid": bookUid, "deleted_at": nil})
+
+	return r.execUpdate(ctx, builder, errBookNotFound)
+}
+
+// SetLibraryBookStock добавляет книгу в библиотеку (или восстанавливает удаленную запись) и доводит число
+// доступных экземпляров до availableCount: недостающие добавляются, лишние доступные удаляются.
+// Выданные экземпляры не затрагиваются.
+func (r *repository) SetLibraryBookStock(ctx context.Context, libraryUid, bookUid string, availableCount int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	lb := libraryBook{}
+	err = tx.GetContext(ctx, &lb, `
+SELECT l.id AS library_id, b.id AS book_id
+FROM library l, books b
+WHERE l.library_uid = $1 AND b.book_uid = $2 AND l.deleted_at IS NULL AND b.deleted_at IS NULL;
+`, libraryUid, bookUid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.Wrap(errRecordNotFound, "library or book not found")
+		}
+		return 0, errors.Wrap(err, "failed to get library book")
+	}
+
+	err = setStock(ctx, tx, lb, availableCount)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to commit transaction")
+	}
+
+	return availableCount, nil
+}
+
+// setStock добавляет книгу в библиотеку (или восстанавливает удаленную запись) и доводит число
+// доступных экземпляров до availableCount
+func setStock(ctx context.Context, tx *sqlx.Tx, lb libraryBook, availableCount int) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO library_books (book_id, library_id)
+VALUES ($1, $2)
+ON CONFLICT (library_id, book_id) DO UPDATE SET deleted_at = NULL;
+`, lb.BookID, lb.LibraryID)
+	if err != nil {
+		return errors.Wrap(err, "failed to upsert library book")
+	}
+
+	ids := make([]int, 0)
+	err = tx.SelectContext(ctx, &ids, `
+SELECT id FROM book_copies
+WHERE library_id = $1 AND book_id = $2 AND status = $3
+ORDER BY id
+FOR UPDATE;
+`, lb.LibraryID, lb.BookID, copyStatusAvailable)
+	if err != nil {
+		return errors.Wrap(err, "failed to lock copies")
+	}
+
+	switch {
+	case len(ids) < availableCount:
+		return addCopies(ctx, tx, lb, availableCount-len(ids))
+	case len(ids) > availableCount:
+		_, err = tx.ExecContext(ctx, `DELETE FROM book_copies WHERE id = ANY($1);`, pq.Array(ids[availableCount:]))
+		if err != nil {
+			return errors.Wrap(err, "failed to delete copies")
+		}
+	}
+	return nil
+}
+
+// DeleteLibraryBook помечает книгу в библиотеке удаленной, экземпляры остаются для возврата выданных
+func (r *repository) DeleteLibraryBook(ctx context.Context, libraryUid, bookUid string) error {
+	query := `
+UPDATE library_books lb
+SET deleted_at = now()
+FROM library l, books b
+WHERE lb.library_id = l.id AND lb.book_id = b.id
+  AND l.library_uid = $1 AND b.book_uid = $2 AND lb.deleted_at IS NULL;
+`
 
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
-	res, err := r.conn.ExecContext(ctx, query, args...)
+	res, err := r.conn.ExecContext(ctx, query, libraryUid, bookUid)
 	if err != nil {
 		return errors.Wrap(err, "failed to execute query")
 	}
@@ -185,10 +662,274 @@ AND library_id = (
 	if err != nil {
 		return errors.Wrap(err, "failed to get rows affected")
 	}
+	if rowsAffected == 0 {
+		return errRecordNotFound
+	}
+
+	return nil
+}
+
+// execUpdate выполняет изменение и возвращает notFound, если не изменено ни одной записи
+func (r *repository) execUpdate(ctx context.Context, builder sq.UpdateBuilder, notFound error) error {
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "failed to build query")
+	}
 
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	res, err := r.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute query")
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to get rows affected")
+	}
 	if rowsAffected == 0 {
-		return errors.Wrap(errRecordNotFound, "no rows affected")
+		return notFound
 	}
 
 	return nil
 }
+
+// ImportCatalog импортирует строки каталога в одной транзакции и возвращает результат по каждой строке в порядке rows.
+// Ошибка строки откатывает только ее изменения, но тогда, как и при dryRun, вся транзакция откатывается:
+// каталог импортируется целиком или не импортируется вовсе.
+func (r *repository) ImportCatalog(ctx context.Context, rows []catalogRow, dryRun bool) ([]importResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, catalogTimeout)
+	defer cancel()
+
+	tx, err := r.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	results := make([]importResult, 0, len(rows))
+	failed := false
+	for _, row := range rows {
+		_, err = tx.ExecContext(ctx, `SAVEPOINT catalog_row;`)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create savepoint")
+		}
+
+		res, rowErr := importCatalogRow(ctx, tx, row)
+		if rowErr != nil {
+			failed = true
+			res.Status = importStatusError
+			res.Error = rowErr.Error()
+			_, err = tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT catalog_row;`)
+		} else {
+			_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT catalog_row;`)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to finish savepoint")
+		}
+		results = append(results, res)
+	}
+
+	if dryRun || failed {
+		return results, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to commit transaction")
+	}
+
+	return results, nil
+}
+
+// importCatalogRow создает или обновляет книгу (по uid, а без него - по isbn) и, если задана библиотека,
+// устанавливает число ее доступных экземпляров
+func importCatalogRow(ctx context.Context, tx *sqlx.Tx, row catalogRow) (importResult, error) {
+	res := importResult{BookUid: row.BookUid, LibraryUid: row.LibraryUid}
+
+	bookUid, conflictColumn := row.BookUid, "book_uid"
+	if bookUid == "" {
+		bookUid, conflictColumn = uuid.NewString(), "isbn"
+	}
+	condition := row.Condition
+	if condition == "" {
+		condition = "EXCELLENT"
+	}
+
+	query := `
+INSERT INTO books (book_uid, isbn, name, author, genre, condition)
+VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+ON CONFLICT (` + conflictColumn + `) DO UPDATE
+SET isbn       = COALESCE(EXCLUDED.isbn, books.isbn),
+    name       = EXCLUDED.name,
+    author     = EXCLUDED.author,
+    genre      = EXCLUDED.genre,
+    condition  = EXCLUDED.condition,
+    deleted_at = NULL
+RETURNING id, book_uid, xmax = 0 AS created;
+`
+	upserted := struct {
+		ID      int    `db:"id"`
+		BookUid string `db:"book_uid"`
+		Created bool   `db:"created"`
+	}{}
+	err := tx.GetContext(ctx, &upserted, query, bookUid, row.Isbn, row.Name, row.Author, row.Genre, condition)
+	if err != nil {
+		return res, errors.Wrap(err, "failed to upsert book")
+	}
+
+	res.BookUid = upserted.BookUid
+	res.Status = importStatusUpdated
+	if upserted.Created {
+		res.Status = importStatusCreated
+	}
+
+	if row.LibraryUid == "" {
+		return res, nil
+	}
+
+	lb := libraryBook{BookID: upserted.ID}
+	err = tx.GetContext(ctx, &lb.LibraryID, `SELECT id FROM library WHERE library_uid = $1 AND deleted_at IS NULL;`, row.LibraryUid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return res, errLibraryNotFound
+		}
+		return res, errors.Wrap(err, "failed to get library")
+	}
+
+	return res, setStock(ctx, tx, lb, *row.AvailableCount)
+}
+
+// ExportCatalog передает в fn строки каталога по мере чтения: книгу с остатком в каждой ее библиотеке
+// или, если книги нет ни в одной библиотеке, одну строку без библиотеки. Удаленные записи не выгружаются.
+func (r *repository) ExportCatalog(ctx context.Context, fn func(row catalogRow) error) error {
+	query := `
+SELECT b.book_uid,
+       COALESCE(b.isbn, '')                 AS isbn,
+       b.name,
+       COALESCE(b.author, '')               AS author,
+       COALESCE(b.genre, '')                AS genre,
+       COALESCE(b.condition, 'EXCELLENT')   AS condition,
+       COALESCE(l.library_uid::text, '')    AS library_uid,
+       CASE WHEN l.id IS NOT NULL THEN ` + availableCopiesColumn + ` END AS available_count
+FROM books b
+LEFT JOIN (library_books lb JOIN library l ON l.id = lb.library_id AND l.deleted_at IS NULL)
+    ON lb.book_id = b.id AND lb.deleted_at IS NULL
+LEFT JOIN book_copies c ON c.library_id = l.id AND c.book_id = b.id
+WHERE b.deleted_at IS NULL
+GROUP BY b.id, l.id
+ORDER BY b.id, l.id;
+`
+
+	ctx, cancel := context.WithTimeout(ctx, catalogTimeout)
+	defer cancel()
+
+	rows, err := r.conn.QueryxContext(ctx, query)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := catalogRow{}
+		err = rows.StructScan(&row)
+		if err != nil {
+			return errors.Wrap(err, "failed to scan row")
+		}
+
+		err = fn(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(rows.Err(), "failed to read rows")
+}
+
+// libraryMatchesSearch - условие на библиотеку l с книгой lb из поиска: $2 - город (пустой - любой),
+// $3 - только библиотеки с доступными экземплярами
+const libraryMatchesSearch = `
+lb.deleted_at IS NULL AND l.deleted_at IS NULL
+AND ($2 = '' OR l.city = $2)
+AND (NOT $3 OR EXISTS (
+    SELECT 1 FROM book_copies c
+    WHERE c.library_id = lb.library_id AND c.book_id = lb.book_id AND c.status = '` + copyStatusAvailable + `'
+))`
+
+// booksMatchSearch - книги b, найденные запросом q ($1) и имеющиеся хотя бы в одной библиотеке из libraryMatchesSearch
+const booksMatchSearch = `
+FROM books b, websearch_to_tsquery('simple', $1) q
+WHERE b.deleted_at IS NULL
+  AND b.search_vector @@ q
+  AND EXISTS (
+    SELECT 1 FROM library_books lb
+    JOIN library l ON l.id = lb.library_id
+    WHERE lb.book_id = b.id AND ` + libraryMatchesSearch + `
+  )`
+
+// SearchBooks ищет книги полнотекстовым запросом по названию, автору и жанру среди книг, которые есть
+// хотя бы в одной подходящей под фильтр библиотеке. Книги упорядочены по релевантности, у каждой перечислены
+// подходящие библиотеки. Возвращает страницу книг и общее число найденных.
+func (r *repository) SearchBooks(ctx context.Context, filter bookSearchFilter, offset, limit int) ([]foundBook, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	// общее число считается отдельно от страницы: страница за концом выдачи пуста, но число найденных известно
+	total := 0
+	err := r.conn.GetContext(ctx, &total, `SELECT count(*) `+booksMatchSearch+`;`, filter.Query, filter.City, filter.Available)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to count found books")
+	}
+	if total == 0 {
+		return []foundBook{}, 0, nil
+	}
+
+	booksQuery := `
+SELECT b.id, b.book_uid, b.name,
+       COALESCE(b.author, '')             AS author,
+       COALESCE(b.genre, '')              AS genre,
+       COALESCE(b.condition, 'EXCELLENT') AS condition
+` + booksMatchSearch + `
+ORDER BY ts_rank(b.search_vector, q) DESC, b.id
+LIMIT $4 OFFSET $5;
+`
+	books := make([]foundBook, 0)
+	err = r.conn.SelectContext(ctx, &books, booksQuery, filter.Query, filter.City, filter.Available, limit, offset)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to search books")
+	}
+	if len(books) == 0 {
+		return books, total, nil
+	}
+
+	ids := make([]int, 0, len(books))
+	for _, v := range books {
+		ids = append(ids, v.ID)
+	}
+
+	librariesQuery := `
+SELECT lb.book_id, l.library_uid, l.name, l.address, l.city, ` + availableCopiesColumn + ` AS available_count
+FROM library_books lb
+JOIN library l ON l.id = lb.library_id
+LEFT JOIN book_copies c ON c.library_id = lb.library_id AND c.book_id = lb.book_id
+WHERE lb.book_id = ANY($1) AND ` + libraryMatchesSearch + `
+GROUP BY lb.book_id, l.id
+ORDER BY l.id;
+`
+	libraries := make([]bookLibrary, 0)
+	err = r.conn.SelectContext(ctx, &libraries, librariesQuery, pq.Array(ids), filter.City, filter.Available)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to get book libraries")
+	}
+
+	byBook := make(map[int][]bookLibrary, len(books))
+	for _, v := range libraries {
+		byBook[v.BookID] = append(byBook[v.BookID], v)
+	}
+	for i := range books {
+		books[i].Libraries = byBook[books[i].ID]
+	}
+
+	return books, total, nil
+}
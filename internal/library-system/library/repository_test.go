@@ -0,0 +1,359 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestRepository подключается к базе с примененными миграциями library-system из POSTGRESQL_DSN
+func newTestRepository(t *testing.T) (*repository, *sqlx.DB) {
+	dsn := os.Getenv("POSTGRESQL_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRESQL_DSN is not set")
+	}
+
+	conn, err := sqlx.Connect("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return NewRepository(conn), conn
+}
+
+// createLibraryBook добавляет библиотеку с count экземплярами книги и возвращает их uid
+func createLibraryBook(t *testing.T, conn *sqlx.DB, count int) (string, string) {
+	libraryUid, bookUid := uuid.NewString(), uuid.NewString()
+
+	var libraryId, bookId int
+	err := conn.Get(&libraryId, `INSERT INTO library (library_uid, name, city, address) VALUES ($1, 'test', 'test', 'test') RETURNING id`, libraryUid)
+	require.NoError(t, err)
+	err = conn.Get(&bookId, `INSERT INTO books (book_uid, name) VALUES ($1, 'test') RETURNING id`, bookUid)
+	require.NoError(t, err)
+	_, err = conn.Exec(`INSERT INTO library_books (book_id, library_id) VALUES ($1, $2)`, bookId, libraryId)
+	require.NoError(t, err)
+	_, err = conn.Exec(`INSERT INTO book_copies (book_id, library_id) SELECT $1, $2 FROM generate_series(1, $3)`, bookId, libraryId, count)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = conn.Exec(`DELETE FROM applied_operations WHERE library_uid = $1`, libraryUid)
+		_, _ = conn.Exec(`DELETE FROM book_copies WHERE library_id = $1`, libraryId)
+		_, _ = conn.Exec(`DELETE FROM library_books WHERE library_id = $1`, libraryId)
+		_, _ = conn.Exec(`DELETE FROM books WHERE id = $1`, bookId)
+		_, _ = conn.Exec(`DELETE FROM library WHERE id = $1`, libraryId)
+	})
+	return libraryUid, bookUid
+}
+
+func Test_UpdateBooksAvailableCountDoesNotOversell(t *testing.T) {
+	r, conn := newTestRepository(t)
+	const copies, reservations = 3, 20
+	libraryUid, bookUid := createLibraryBook(t, conn, copies)
+
+	var wg sync.WaitGroup
+	errs := make([]error, reservations)
+	for i := 0; i < reservations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = r.UpdateBooksAvailableCount(context.Background(), libraryUid, bookUid, -1, "", false, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		require.ErrorIs(t, err, errNotEnoughCopies)
+	}
+	require.Equal(t, copies, succeeded)
+
+	count, err := r.getBooksAvailableCount(context.Background(), conn, libraryUid, bookUid)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
+func Test_RepositoryUpdateBooksAvailableCount(t *testing.T) {
+	r, conn := newTestRepository(t)
+	libraryUid, bookUid := createLibraryBook(t, conn, 3)
+	ctx := context.Background()
+
+	op := &operation{ID: uuid.NewString(), LibraryUid: libraryUid, BookUid: bookUid, CountDiff: -1}
+	count, err := r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, -1, "", false, op)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	_, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, -1, "", false, op)
+	require.ErrorIs(t, err, errOperationApplied)
+
+	_, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, -3, "", false, nil)
+	require.ErrorIs(t, err, errNotEnoughCopies)
+
+	count, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, 2, "", false, nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	_, err = r.UpdateBooksAvailableCount(ctx, libraryUid, uuid.NewString(), 1, "", false, nil)
+	require.ErrorIs(t, err, errRecordNotFound)
+
+	count, err = r.getBooksAvailableCount(ctx, conn, libraryUid, bookUid)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+}
+
+func Test_BookCopiesBoundToReservation(t *testing.T) {
+	r, conn := newTestRepository(t)
+	libraryUid, bookUid := createLibraryBook(t, conn, 2)
+	ctx := context.Background()
+	reservationUid := uuid.NewString()
+
+	count, err := r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, -1, reservationUid, false, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	rented, err := r.GetCopyByReservation(ctx, reservationUid)
+	require.NoError(t, err)
+	require.Equal(t, copyStatusRented, rented.Status)
+	require.Equal(t, bookUid, rented.BookUid)
+
+	err = r.UpdateCopyCondition(ctx, rented.CopyUid, "BAD")
+	require.NoError(t, err)
+
+	count, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, 1, reservationUid, false, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	_, err = r.GetCopyByReservation(ctx, reservationUid)
+	require.ErrorIs(t, err, errCopyNotFound)
+
+	copies, err := r.GetBookCopies(ctx, libraryUid, bookUid)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	for _, v := range copies {
+		require.Equal(t, copyStatusAvailable, v.Status)
+		if v.CopyUid == rented.CopyUid {
+			require.Equal(t, "BAD", v.Condition)
+		}
+	}
+
+	// возврат без выданного по брони экземпляра число не меняет
+	count, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, 1, uuid.NewString(), false, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	err = r.UpdateCopyCondition(ctx, uuid.NewString(), "BAD")
+	require.ErrorIs(t, err, errCopyNotFound)
+}
+
+func Test_ReturnBookRentedBeforeCopies(t *testing.T) {
+	r, conn := newTestRepository(t)
+	// миграция создала только доступный экземпляр, выданный до нее экземпляр не создан
+	libraryUid, bookUid := createLibraryBook(t, conn, 1)
+	ctx := context.Background()
+	reservationUid := uuid.NewString()
+
+	// отмена выдачи без выданного экземпляра число не меняет
+	count, err := r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, 1, reservationUid, false, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// возврат книги, выданной до учета экземпляров, добавляет экземпляр
+	op := &operation{ID: uuid.NewString(), LibraryUid: libraryUid, BookUid: bookUid, CountDiff: 1}
+	count, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, 1, reservationUid, true, op)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	_, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, 1, reservationUid, true, op)
+	require.ErrorIs(t, err, errOperationApplied)
+
+	// возврат книги с выданным экземпляром только освобождает его
+	rentedUid := uuid.NewString()
+	_, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, -1, rentedUid, false, nil)
+	require.NoError(t, err)
+	count, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, 1, rentedUid, true, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	copies, err := r.GetBookCopies(ctx, libraryUid, bookUid)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+}
+
+func Test_RepositorySetLibraryBookStock(t *testing.T) {
+	r, conn := newTestRepository(t)
+	libraryUid, bookUid := createLibraryBook(t, conn, 2)
+	ctx := context.Background()
+
+	_, err := r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, -1, uuid.NewString(), false, nil)
+	require.NoError(t, err)
+
+	count, err := r.SetLibraryBookStock(ctx, libraryUid, bookUid, 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	copies, err := r.GetBookCopies(ctx, libraryUid, bookUid)
+	require.NoError(t, err)
+	require.Len(t, copies, 1)
+	require.Equal(t, copyStatusRented, copies[0].Status)
+
+	err = r.DeleteLibraryBook(ctx, libraryUid, bookUid)
+	require.NoError(t, err)
+	_, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, -1, "", false, nil)
+	require.ErrorIs(t, err, errRecordNotFound)
+
+	// повторная установка остатка восстанавливает удаленную запись
+	count, err = r.SetLibraryBookStock(ctx, libraryUid, bookUid, 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	count, err = r.getBooksAvailableCount(ctx, conn, libraryUid, bookUid)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	err = r.DeleteBook(ctx, bookUid)
+	require.NoError(t, err)
+	_, err = r.SetLibraryBookStock(ctx, libraryUid, bookUid, 1)
+	require.ErrorIs(t, err, errRecordNotFound)
+	err = r.DeleteBook(ctx, bookUid)
+	require.ErrorIs(t, err, errBookNotFound)
+}
+
+func Test_RepositoryImportCatalog(t *testing.T) {
+	r, conn := newTestRepository(t)
+	libraryUid, bookUid := createLibraryBook(t, conn, 1)
+	ctx := context.Background()
+	isbn := uuid.NewString()[:17]
+	t.Cleanup(func() {
+		_, _ = conn.Exec(`DELETE FROM book_copies WHERE book_id IN (SELECT id FROM books WHERE isbn = $1)`, isbn)
+		_, _ = conn.Exec(`DELETE FROM library_books WHERE book_id IN (SELECT id FROM books WHERE isbn = $1)`, isbn)
+		_, _ = conn.Exec(`DELETE FROM books WHERE isbn = $1`, isbn)
+	})
+
+	stock := 4
+	rows := []catalogRow{
+		{BookUid: bookUid, Name: "renamed", LibraryUid: libraryUid, AvailableCount: &stock},
+		{Isbn: isbn, Name: "new", LibraryUid: libraryUid, AvailableCount: &stock},
+	}
+
+	results, err := r.ImportCatalog(ctx, rows, true)
+	require.NoError(t, err)
+	require.Equal(t, importStatusUpdated, results[0].Status)
+	require.Equal(t, importStatusCreated, results[1].Status)
+	count, err := r.getBooksAvailableCount(ctx, conn, libraryUid, bookUid)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// строка с ошибкой откатывает весь импорт
+	results, err = r.ImportCatalog(ctx, append(rows, catalogRow{Isbn: uuid.NewString()[:17], Name: "test", LibraryUid: uuid.NewString(), AvailableCount: &stock}), false)
+	require.NoError(t, err)
+	require.Equal(t, importStatusError, results[2].Status)
+	count, err = r.getBooksAvailableCount(ctx, conn, libraryUid, bookUid)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	results, err = r.ImportCatalog(ctx, rows, false)
+	require.NoError(t, err)
+	count, err = r.getBooksAvailableCount(ctx, conn, libraryUid, results[1].BookUid)
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+
+	exported := map[string]catalogRow{}
+	err = r.ExportCatalog(ctx, func(row catalogRow) error {
+		exported[row.BookUid] = row
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "renamed", exported[bookUid].Name)
+	require.Equal(t, isbn, exported[results[1].BookUid].Isbn)
+}
+
+func Test_RepositorySearchBooks(t *testing.T) {
+	r, conn := newTestRepository(t)
+	libraryUid, bookUid := createLibraryBook(t, conn, 1)
+	ctx := context.Background()
+
+	word := "w" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err := conn.Exec(`UPDATE books SET name = $1, author = 'Test Author', genre = 'Test Genre' WHERE book_uid = $2`, "Book "+word, bookUid)
+	require.NoError(t, err)
+	_, err = conn.Exec(`UPDATE library SET city = $1 WHERE library_uid = $2`, word, libraryUid)
+	require.NoError(t, err)
+
+	books, total, err := r.SearchBooks(ctx, bookSearchFilter{Query: word, Available: true}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Equal(t, bookUid, books[0].BookUid)
+	require.Len(t, books[0].Libraries, 1)
+	require.Equal(t, libraryUid, books[0].Libraries[0].LibraryUid)
+	require.Equal(t, 1, books[0].Libraries[0].AvailableCount)
+
+	_, total, err = r.SearchBooks(ctx, bookSearchFilter{Query: word, City: "other"}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 0, total)
+
+	_, err = r.UpdateBooksAvailableCount(ctx, libraryUid, bookUid, -1, "", false, nil)
+	require.NoError(t, err)
+
+	_, total, err = r.SearchBooks(ctx, bookSearchFilter{Query: word, City: word, Available: true}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 0, total)
+
+	books, total, err = r.SearchBooks(ctx, bookSearchFilter{Query: word + " author", City: word}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Equal(t, 0, books[0].Libraries[0].AvailableCount)
+
+	// страница за концом выдачи пуста, но общее число найденных сохраняется
+	books, total, err = r.SearchBooks(ctx, bookSearchFilter{Query: word}, 10, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Empty(t, books)
+}
+
+// recordingConn - соединение database/sql, которое запоминает выполненные запросы и ни одной строки не меняет.
+// Позволяет проверить запросы репозитория без базы.
+type recordingConn struct {
+	queries []string
+}
+
+func (c *recordingConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *recordingConn) Driver() driver.Driver                        { return nil }
+func (c *recordingConn) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *recordingConn) Close() error                                 { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error)                    { return c, nil }
+func (c *recordingConn) Commit() error                                { return nil }
+func (c *recordingConn) Rollback() error                              { return nil }
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.queries = append(c.queries, query)
+	return driver.RowsAffected(0), nil
+}
+
+func Test_ReleaseCopiesWithoutBoundCopy(t *testing.T) {
+	conn := &recordingConn{}
+	db := sqlx.NewDb(sql.OpenDB(conn), "postgres")
+	defer db.Close()
+
+	tx, err := db.Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	// по брони не выдано ни одного экземпляра: ничего не освобождается и не добавляется
+	released, err := releaseCopies(context.Background(), tx, libraryBook{LibraryID: 1, BookID: 1}, 1, uuid.NewString())
+	require.NoError(t, err)
+	require.Equal(t, 0, released)
+
+	require.Len(t, conn.queries, 1)
+	require.Contains(t, conn.queries[0], "UPDATE book_copies")
+	for _, query := range conn.queries {
+		require.NotContains(t, query, "INSERT")
+	}
+}
@@ -47,7 +47,11 @@ func (r *root) Register(ctx context.Context) error {
 
 	libraryRepo := library.NewRepository(psqldb)
 
-	libraryHandler := library.NewHandler(libraryRepo)
+	if r.cfg.Admin.Token == "" {
+		log.Warn().Msg("ADMIN_TOKEN is not set, admin api is disabled")
+	}
+
+	libraryHandler := library.NewHandler(libraryRepo, r.cfg.Admin)
 
 	r.server = http.NewServer(&r.cfg.Server, libraryHandler)
 
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
@@ -16,15 +17,39 @@ type PostgreSQL struct {
 	DSN string `env:"POSTGRESQL_DSN"`
 }
 
+// Rating - границы рейтинга: изменение, выходящее за них, ограничивается min_stars или max_stars
+type Rating struct {
+	MinStars int `yaml:"min_stars"`
+	MaxStars int `yaml:"max_stars"`
+}
+
+// Admin - доступ к административному API, токен задается переменной окружения ADMIN_TOKEN
+type Admin struct {
+	Token string `env:"ADMIN_TOKEN"`
+}
+
+func (c Rating) Validate() error {
+	if c.MinStars < 0 {
+		return fmt.Errorf("rating: min_stars must not be negative, got %d", c.MinStars)
+	}
+	if c.MaxStars < c.MinStars {
+		return errors.New("rating: max_stars must not be less than min_stars")
+	}
+	return nil
+}
+
 type Config struct {
 	Server     Server `yaml:"server"`
+	Rating     Rating `yaml:"rating"`
 	PostgreSQL PostgreSQL
+	Admin      Admin
 }
 
 func New() (*Config, error) {
 	cfg := &Config{}
 
 	cfg.PostgreSQL.DSN = os.Getenv("POSTGRESQL_DSN")
+	cfg.Admin.Token = os.Getenv("ADMIN_TOKEN")
 
 	yamlFile, err := os.ReadFile(fmt.Sprint("./configs/rating-system/config.yml"))
 	if err != nil {
@@ -34,5 +59,9 @@ func New() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = cfg.Rating.Validate()
+	if err != nil {
+		return nil, err
+	}
 	return cfg, err
 }
@@ -16,6 +16,8 @@ type ratingHandler interface {
 	GetRatingRecord(c echo.Context) error
 	CreateRatingRecord(c echo.Context) error
 	UpdateRatingRecord(c echo.Context) error
+	GetRatingHistory(c echo.Context) error
+	RebuildRatingRecord(c echo.Context) error
 }
 
 type server struct {
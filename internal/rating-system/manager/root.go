@@ -47,7 +47,11 @@ func (r *root) Register(ctx context.Context) error {
 
 	ratingRepo := rating.NewRepository(psqldb)
 
-	personHandler := rating.NewHandler(ratingRepo)
+	if r.cfg.Admin.Token == "" {
+		log.Warn().Msg("ADMIN_TOKEN is not set, rating rebuild is disabled")
+	}
+
+	personHandler := rating.NewHandler(ratingRepo, r.cfg.Rating, r.cfg.Admin)
 
 	r.server = http.NewServer(&r.cfg.Server, personHandler)
 
@@ -4,4 +4,12 @@ import "errors"
 
 var (
 	errRecordNotFound = errors.New("record not found")
+	// errEventsNotFound - в журнале нет событий, по которым можно восстановить рейтинг
+	errEventsNotFound = errors.New("rating events not found")
+
+	errOperationNotFound = errors.New("operation not found")
+	// errOperationApplied - операция с этим идентификатором уже применена
+	errOperationApplied = errors.New("operation already applied")
+	// errOperationConflict - идентификатор операции уже использован для другого изменения
+	errOperationConflict = errors.New("operation id is already used for another update")
 )
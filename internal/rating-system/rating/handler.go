@@ -4,27 +4,36 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"github.com/Erlendum/rsoi-lab-03/internal/rating-system/config"
+	"github.com/Erlendum/rsoi-lab-03/pkg/auth"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 //go:generate mockgen -source=handler.go -destination=handler_mocks.go -self_package=github.com/Erlendum/rsoi-lab-02/internal/rating-system/rating -package=rating
 
 type storage interface {
 	CreateRatingRecord(ctx context.Context, record *ratingRecord) (int, error)
-	UpdateRatingRecord(ctx context.Context, userName string, record *ratingRecord) error
+	UpdateRatingStars(ctx context.Context, event ratingEvent, minStars, maxStars int, op *operation) (int, error)
 	GetRatingRecord(ctx context.Context, username string) (ratingRecord, error)
+	GetOperation(ctx context.Context, operationId string) (operation, error)
+	GetRatingEvents(ctx context.Context, username string, offset, limit int) ([]ratingEvent, int, error)
+	RebuildRatingStars(ctx context.Context, username string, minStars, maxStars int) (int, error)
 }
 
 type handler struct {
 	storage storage
+	cfg     config.Rating
+	admin   config.Admin
 }
 
-func NewHandler(storage storage) *handler {
-	return &handler{storage: storage}
+func NewHandler(storage storage, cfg config.Rating, admin config.Admin) *handler {
+	return &handler{storage: storage, cfg: cfg, admin: admin}
 }
 
 func (h *handler) Register(echo *echo.Echo) {
@@ -33,6 +42,8 @@ func (h *handler) Register(echo *echo.Echo) {
 	api.GET("/rating/:username", h.GetRatingRecord)
 	api.POST("/rating", h.CreateRatingRecord)
 	api.PUT("/rating/:username", h.UpdateRatingRecord)
+	api.GET("/rating/:username/history", h.GetRatingHistory)
+	api.POST("/rating/:username/rebuild", h.RebuildRatingRecord, auth.AdminToken(h.admin.Token))
 }
 
 func (h *handler) GetRatingRecord(c echo.Context) error {
@@ -50,11 +61,7 @@ func (h *handler) GetRatingRecord(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "storage error"})
 	}
 
-	type response struct {
-		Stars int `json:"stars"`
-	}
-
-	return c.JSON(http.StatusOK, response{Stars: *record.Stars})
+	return c.JSON(http.StatusOK, starsResponse{Stars: *record.Stars})
 }
 
 func (h *handler) CreateRatingRecord(c echo.Context) error {
@@ -100,22 +107,149 @@ func (h *handler) UpdateRatingRecord(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"message": "starsDiff is wrong"})
 	}
 
-	record, err := h.storage.GetRatingRecord(c.Request().Context(), username)
+	event := ratingEvent{UserName: username, Delta: starsDiff, Reason: c.QueryParam("reason")}
+	if event.Reason == "" {
+		event.Reason = reasonUnspecified
+	}
+	if len(event.Reason) > maxReasonLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "reason is wrong"})
+	}
+	if reservationUid := c.QueryParam("reservationUid"); reservationUid != "" {
+		if _, err = uuid.Parse(reservationUid); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": "reservationUid is wrong"})
+		}
+		event.ReservationUid = &reservationUid
+	}
+
+	// с operationId изменение применяется один раз, повтор возвращает исходный результат
+	var op *operation
+	if operationId := c.QueryParam("operationId"); operationId != "" {
+		op = &operation{ID: operationId, UserName: username, StarsDiff: starsDiff}
+		stars, err := h.checkOperation(c.Request().Context(), *op)
+		if err != nil {
+			return operationResponse(c, stars, err)
+		}
+	}
+
+	stars, err := h.storage.UpdateRatingStars(c.Request().Context(), event, h.cfg.MinStars, h.cfg.MaxStars, op)
+	if errors.Is(err, errOperationApplied) || errors.Is(err, errOperationConflict) {
+		return operationResponse(c, stars, err)
+	}
 	if err != nil {
-		log.Err(err).Msg("failed to get rating record")
+		log.Err(err).Msg("failed to update rating record")
 		if errors.Is(err, errRecordNotFound) {
 			return c.JSON(http.StatusNotFound, echo.Map{"message": "record not found"})
 		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to update rating record"})
+	}
+
+	return c.JSON(http.StatusOK, starsResponse{Stars: stars})
+}
+
+func (h *handler) GetRatingHistory(c echo.Context) error {
+	username := c.Param("username")
+	if username == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "username is wrong"})
+	}
+
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page <= 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "page is wrong"})
+	}
+
+	size, err := strconv.Atoi(c.QueryParam("size"))
+	if err != nil || size <= 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "size is wrong"})
+	}
+
+	events, total, err := h.storage.GetRatingEvents(c.Request().Context(), username, page*size-size, size)
+	if err != nil {
+		log.Err(err).Msg("failed to get rating events")
 		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "storage error"})
 	}
 
-	newStars := *record.Stars + starsDiff
+	type item struct {
+		Reason         string    `json:"reason"`
+		Delta          int       `json:"delta"`
+		Stars          int       `json:"stars"`
+		ReservationUid *string   `json:"reservationUid"`
+		Date           time.Time `json:"date"`
+	}
+	type response struct {
+		Page          int    `json:"page"`
+		PageSize      int    `json:"pageSize"`
+		TotalElements int    `json:"totalElements"`
+		Items         []item `json:"items"`
+	}
+
+	items := make([]item, 0, len(events))
+	for _, v := range events {
+		items = append(items, item{
+			Reason:         v.Reason,
+			Delta:          v.Delta,
+			Stars:          v.Stars,
+			ReservationUid: v.ReservationUid,
+			Date:           v.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, response{
+		Page:          page,
+		PageSize:      size,
+		TotalElements: total,
+		Items:         items,
+	})
+}
 
-	err = h.storage.UpdateRatingRecord(c.Request().Context(), username, &ratingRecord{Stars: &newStars})
+// RebuildRatingRecord восстанавливает рейтинг пользователя по журналу изменений
+func (h *handler) RebuildRatingRecord(c echo.Context) error {
+	username := c.Param("username")
+	if username == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "username is wrong"})
+	}
+
+	stars, err := h.storage.RebuildRatingStars(c.Request().Context(), username, h.cfg.MinStars, h.cfg.MaxStars)
 	if err != nil {
-		log.Err(err).Msg("failed to create rating record")
-		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to create rating record"})
+		log.Err(err).Msg("failed to rebuild rating record")
+		if errors.Is(err, errRecordNotFound) || errors.Is(err, errEventsNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"message": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to rebuild rating record"})
 	}
 
-	return c.NoContent(http.StatusOK)
+	return c.JSON(http.StatusOK, starsResponse{Stars: stars})
+}
+
+type starsResponse struct {
+	Stars int `json:"stars"`
+}
+
+// checkOperation возвращает errOperationApplied с исходным рейтингом, если op уже применена,
+// и errOperationConflict, если ее идентификатор использован для другого изменения
+func (h *handler) checkOperation(ctx context.Context, op operation) (int, error) {
+	applied, err := h.storage.GetOperation(ctx, op.ID)
+	if errors.Is(err, errOperationNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !applied.sameAs(op) {
+		return 0, errOperationConflict
+	}
+	return applied.Stars, errOperationApplied
+}
+
+// operationResponse отвечает на повтор операции рейтингом stars, с которым она была применена
+func operationResponse(c echo.Context, stars int, err error) error {
+	switch {
+	case errors.Is(err, errOperationApplied):
+		log.Info().Msg("operation already applied")
+		return c.JSON(http.StatusOK, starsResponse{Stars: stars})
+	case errors.Is(err, errOperationConflict):
+		return c.JSON(http.StatusConflict, echo.Map{"message": errOperationConflict.Error()})
+	default:
+		log.Err(err).Msg("failed to get operation")
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "storage error"})
+	}
 }
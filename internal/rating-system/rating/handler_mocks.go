@@ -49,6 +49,37 @@ func (mr *MockstorageMockRecorder) CreateRatingRecord(ctx, record interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRatingRecord", reflect.TypeOf((*Mockstorage)(nil).CreateRatingRecord), ctx, record)
 }
 
+// GetOperation mocks base method.
+func (m *Mockstorage) GetOperation(ctx context.Context, operationId string) (operation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOperation", ctx, operationId)
+	ret0, _ := ret[0].(operation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOperation indicates an expected call of GetOperation.
+func (mr *MockstorageMockRecorder) GetOperation(ctx, operationId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOperation", reflect.TypeOf((*Mockstorage)(nil).GetOperation), ctx, operationId)
+}
+
+// GetRatingEvents mocks base method.
+func (m *Mockstorage) GetRatingEvents(ctx context.Context, username string, offset, limit int) ([]ratingEvent, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRatingEvents", ctx, username, offset, limit)
+	ret0, _ := ret[0].([]ratingEvent)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetRatingEvents indicates an expected call of GetRatingEvents.
+func (mr *MockstorageMockRecorder) GetRatingEvents(ctx, username, offset, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRatingEvents", reflect.TypeOf((*Mockstorage)(nil).GetRatingEvents), ctx, username, offset, limit)
+}
+
 // GetRatingRecord mocks base method.
 func (m *Mockstorage) GetRatingRecord(ctx context.Context, username string) (ratingRecord, error) {
 	m.ctrl.T.Helper()
@@ -64,16 +95,32 @@ func (mr *MockstorageMockRecorder) GetRatingRecord(ctx, username interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRatingRecord", reflect.TypeOf((*Mockstorage)(nil).GetRatingRecord), ctx, username)
 }
 
-// UpdateRatingRecord mocks base method.
-func (m *Mockstorage) UpdateRatingRecord(ctx context.Context, userName string, record *ratingRecord) error {
+// RebuildRatingStars mocks base method.
+func (m *Mockstorage) RebuildRatingStars(ctx context.Context, username string, minStars, maxStars int) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateRatingRecord", ctx, userName, record)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "RebuildRatingStars", ctx, username, minStars, maxStars)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RebuildRatingStars indicates an expected call of RebuildRatingStars.
+func (mr *MockstorageMockRecorder) RebuildRatingStars(ctx, username, minStars, maxStars interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RebuildRatingStars", reflect.TypeOf((*Mockstorage)(nil).RebuildRatingStars), ctx, username, minStars, maxStars)
+}
+
+// UpdateRatingStars mocks base method.
+func (m *Mockstorage) UpdateRatingStars(ctx context.Context, event ratingEvent, minStars, maxStars int, op *operation) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRatingStars", ctx, event, minStars, maxStars, op)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// UpdateRatingRecord indicates an expected call of UpdateRatingRecord.
-func (mr *MockstorageMockRecorder) UpdateRatingRecord(ctx, userName, record interface{}) *gomock.Call {
+// UpdateRatingStars indicates an expected call of UpdateRatingStars.
+func (mr *MockstorageMockRecorder) UpdateRatingStars(ctx, event, minStars, maxStars, op interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRatingRecord", reflect.TypeOf((*Mockstorage)(nil).UpdateRatingRecord), ctx, userName, record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRatingStars", reflect.TypeOf((*Mockstorage)(nil).UpdateRatingStars), ctx, event, minStars, maxStars, op)
 }
@@ -2,6 +2,7 @@ package rating
 
 import (
 	"errors"
+	"github.com/Erlendum/rsoi-lab-03/internal/rating-system/config"
 	"github.com/Erlendum/rsoi-lab-03/pkg/validation"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang/mock/gomock"
@@ -10,7 +11,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 type handlerTestFields struct {
@@ -119,3 +122,468 @@ func Test_GetRatingRecord(t *testing.T) {
 		})
 	}
 }
+
+func Test_UpdateRatingRecord(t *testing.T) {
+	type fields struct {
+		username             string
+		starsDiff            string
+		operationId          string
+		reason               string
+		reservationUid       string
+		expectedHTTPCode     int
+		expectedResponseBody string
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	cfg := config.Rating{MinStars: 1, MaxStars: 100}
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong starsDiff",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				username:         "test",
+				starsDiff:        "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: wrong reservationUid",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				username:         "test",
+				starsDiff:        "1",
+				reservationUid:   "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: too long reason",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				username:         "test",
+				starsDiff:        "1",
+				reason:           strings.Repeat("A", maxReasonLength+1),
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 404: record not found",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+				username:         "test",
+				starsDiff:        "1",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateRatingStars(gomock.Any(), ratingEvent{UserName: "test", Delta: 1, Reason: reasonUnspecified}, 1, 100, nil).Return(0, errRecordNotFound)
+			},
+		},
+		{
+			name: "http-code 500: storage error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				username:         "test",
+				starsDiff:        "1",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateRatingStars(gomock.Any(), ratingEvent{UserName: "test", Delta: 1, Reason: reasonUnspecified}, 1, 100, nil).Return(0, errors.New(""))
+			},
+		},
+		{
+			name: "http-code 200: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				starsDiff:        "1",
+				expectedResponseBody: `{"stars":51}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateRatingStars(gomock.Any(), ratingEvent{UserName: "test", Delta: 1, Reason: reasonUnspecified}, 1, 100, nil).Return(51, nil)
+			},
+		},
+		{
+			name: "http-code 200: success with reason and reservation",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				starsDiff:        "1",
+				reason:           "RETURNED",
+				reservationUid:   "f7cdc58f-2caf-4b15-9727-f89dcc629b27",
+				expectedResponseBody: `{"stars":51}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				reservationUid := "f7cdc58f-2caf-4b15-9727-f89dcc629b27"
+				fields.storage.EXPECT().UpdateRatingStars(gomock.Any(), ratingEvent{UserName: "test", Delta: 1, Reason: "RETURNED", ReservationUid: &reservationUid}, 1, 100, nil).Return(51, nil)
+			},
+		},
+		{
+			name: "http-code 200: penalty clamped to min stars",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				starsDiff:        "-10",
+				expectedResponseBody: `{"stars":1}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().UpdateRatingStars(gomock.Any(), ratingEvent{UserName: "test", Delta: -10, Reason: reasonUnspecified}, 1, 100, nil).Return(1, nil)
+			},
+		},
+		{
+			name: "http-code 200: success with operation",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				starsDiff:        "1",
+				operationId:      "op",
+				expectedResponseBody: `{"stars":51}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{}, errOperationNotFound)
+				fields.storage.EXPECT().UpdateRatingStars(gomock.Any(), ratingEvent{UserName: "test", Delta: 1, Reason: reasonUnspecified}, 1, 100, &operation{ID: "op", UserName: "test", StarsDiff: 1}).Return(51, nil)
+			},
+		},
+		{
+			name: "http-code 200: operation already applied",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				starsDiff:        "1",
+				operationId:      "op",
+				expectedResponseBody: `{"stars":51}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{ID: "op", UserName: "test", StarsDiff: 1, Stars: 51}, nil)
+			},
+		},
+		{
+			name: "http-code 200: operation applied concurrently",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				starsDiff:        "1",
+				operationId:      "op",
+				expectedResponseBody: `{"stars":51}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{}, errOperationNotFound)
+				fields.storage.EXPECT().UpdateRatingStars(gomock.Any(), ratingEvent{UserName: "test", Delta: 1, Reason: reasonUnspecified}, 1, 100, &operation{ID: "op", UserName: "test", StarsDiff: 1}).Return(51, errOperationApplied)
+			},
+		},
+		{
+			name: "http-code 409: operation id used for another update",
+			fields: fields{
+				expectedHTTPCode: http.StatusConflict,
+				username:         "test",
+				starsDiff:        "1",
+				operationId:      "op",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{ID: "op", UserName: "test", StarsDiff: -10, Stars: 40}, nil)
+			},
+		},
+		{
+			name: "http-code 500: GetOperation error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				username:         "test",
+				starsDiff:        "1",
+				operationId:      "op",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetOperation(gomock.Any(), "op").Return(operation{}, errors.New(""))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage, cfg: cfg}
+
+			req := httptest.NewRequest(http.MethodPut, "/test?starsDiff="+tt.fields.starsDiff+"&operationId="+tt.fields.operationId+"&reason="+tt.fields.reason+"&reservationUid="+tt.fields.reservationUid, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("username")
+			c.SetParamValues(tt.fields.username)
+
+			err := h.UpdateRatingRecord(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+			if tt.fields.expectedHTTPCode == http.StatusOK {
+				body, err := io.ReadAll(rec.Result().Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.fields.expectedResponseBody, string(body))
+			}
+		})
+	}
+}
+
+func Test_GetRatingHistory(t *testing.T) {
+	type fields struct {
+		username             string
+		page                 string
+		size                 string
+		expectedHTTPCode     int
+		expectedResponseBody string
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	reservationUid := "f7cdc58f-2caf-4b15-9727-f89dcc629b27"
+	date := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 400: wrong page",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				username:         "test",
+				page:             "0",
+				size:             "10",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 400: wrong size",
+			fields: fields{
+				expectedHTTPCode: http.StatusBadRequest,
+				username:         "test",
+				page:             "1",
+				size:             "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+			},
+		},
+		{
+			name: "http-code 500: storage error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				username:         "test",
+				page:             "1",
+				size:             "10",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetRatingEvents(gomock.Any(), "test", 0, 10).Return(nil, 0, errors.New(""))
+			},
+		},
+		{
+			name: "http-code 200: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				page:             "2",
+				size:             "1",
+				expectedResponseBody: `{"page":2,"pageSize":1,"totalElements":2,"items":[{"reason":"INITIAL","delta":50,"stars":50,"reservationUid":null,"date":"2026-10-17T12:00:00Z"}]}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetRatingEvents(gomock.Any(), "test", 1, 1).Return([]ratingEvent{
+					{UserName: "test", Delta: 50, Stars: 50, Reason: reasonInitial, CreatedAt: date},
+				}, 2, nil)
+			},
+		},
+		{
+			name: "http-code 200: event with reservation",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				page:             "1",
+				size:             "1",
+				expectedResponseBody: `{"page":1,"pageSize":1,"totalElements":2,"items":[{"reason":"RETURNED","delta":1,"stars":51,"reservationUid":"f7cdc58f-2caf-4b15-9727-f89dcc629b27","date":"2026-10-17T12:00:00Z"}]}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().GetRatingEvents(gomock.Any(), "test", 0, 1).Return([]ratingEvent{
+					{UserName: "test", Delta: 1, Stars: 51, Reason: "RETURNED", ReservationUid: &reservationUid, CreatedAt: date},
+				}, 2, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage}
+
+			req := httptest.NewRequest(http.MethodGet, "/test?page="+tt.fields.page+"&size="+tt.fields.size, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("username")
+			c.SetParamValues(tt.fields.username)
+
+			err := h.GetRatingHistory(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+			if tt.fields.expectedHTTPCode == http.StatusOK {
+				body, err := io.ReadAll(rec.Result().Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.fields.expectedResponseBody, string(body))
+			}
+		})
+	}
+}
+
+func Test_RebuildRatingRecord(t *testing.T) {
+	type fields struct {
+		username             string
+		expectedHTTPCode     int
+		expectedResponseBody string
+	}
+
+	e := echo.New()
+	e.Validator = validation.MustRegisterCustomValidator(validator.New())
+
+	cfg := config.Rating{MinStars: 1, MaxStars: 100}
+
+	tests := []struct {
+		name    string
+		fields  fields
+		Prepare func(fields *handlerTestFields)
+	}{
+		{
+			name: "http-code 404: record not found",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+				username:         "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().RebuildRatingStars(gomock.Any(), "test", 1, 100).Return(0, errRecordNotFound)
+			},
+		},
+		{
+			name: "http-code 404: no events",
+			fields: fields{
+				expectedHTTPCode: http.StatusNotFound,
+				username:         "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().RebuildRatingStars(gomock.Any(), "test", 1, 100).Return(0, errEventsNotFound)
+			},
+		},
+		{
+			name: "http-code 500: storage error",
+			fields: fields{
+				expectedHTTPCode: http.StatusInternalServerError,
+				username:         "test",
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().RebuildRatingStars(gomock.Any(), "test", 1, 100).Return(0, errors.New(""))
+			},
+		},
+		{
+			name: "http-code 200: success",
+			fields: fields{
+				expectedHTTPCode: http.StatusOK,
+				username:         "test",
+				expectedResponseBody: `{"stars":42}
+`,
+			},
+
+			Prepare: func(fields *handlerTestFields) {
+				fields.storage.EXPECT().RebuildRatingStars(gomock.Any(), "test", 1, 100).Return(42, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			testFields := createHandlerTestFields(ctrl)
+			tt.Prepare(testFields)
+
+			h := &handler{storage: testFields.storage, cfg: cfg}
+
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("username")
+			c.SetParamValues(tt.fields.username)
+
+			err := h.RebuildRatingRecord(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.fields.expectedHTTPCode, rec.Code)
+			if tt.fields.expectedHTTPCode == http.StatusOK {
+				body, err := io.ReadAll(rec.Result().Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.fields.expectedResponseBody, string(body))
+			}
+		})
+	}
+}
+
+func Test_RebuildRatingRecordRequiresAdminToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	testFields := createHandlerTestFields(ctrl)
+	testFields.storage.EXPECT().RebuildRatingStars(gomock.Any(), "test", 1, 100).Return(42, nil)
+
+	e := echo.New()
+	NewHandler(testFields.storage, config.Rating{MinStars: 1, MaxStars: 100}, config.Admin{Token: "secret"}).Register(e)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/rating/test/rebuild", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/rating/test/rebuild", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"stars":42}`, rec.Body.String())
+}
@@ -1,7 +1,43 @@
 package rating
 
+import "time"
+
 type ratingRecord struct {
 	ID       *int    `db:"id"`
 	UserName *string `db:"username"`
 	Stars    *int    `db:"stars"`
 }
+
+// operation - примененное изменение рейтинга, повтор с тем же ID не применяется.
+// Stars - рейтинг после изменения, он возвращается на повтор.
+type operation struct {
+	ID        string `db:"operation_id"`
+	UserName  string `db:"username"`
+	StarsDiff int    `db:"stars_diff"`
+	Stars     int    `db:"stars"`
+}
+
+func (o operation) sameAs(other operation) bool {
+	return o.ID == other.ID && o.UserName == other.UserName && o.StarsDiff == other.StarsDiff
+}
+
+const (
+	// reasonInitial - событие с начальным рейтингом пользователя
+	reasonInitial = "INITIAL"
+	// reasonUnspecified - причина изменения, если клиент ее не передал
+	reasonUnspecified = "UNSPECIFIED"
+
+	maxReasonLength = 40
+)
+
+// ratingEvent - запись журнала изменений рейтинга. Delta - запрошенное изменение,
+// Stars - рейтинг после него с учетом ограничений.
+type ratingEvent struct {
+	ID             int       `db:"id"`
+	UserName       string    `db:"username"`
+	Delta          int       `db:"delta"`
+	Stars          int       `db:"stars"`
+	Reason         string    `db:"reason"`
+	ReservationUid *string   `db:"reservation_uid"`
+	CreatedAt      time.Time `db:"created_at"`
+}
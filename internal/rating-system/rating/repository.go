@@ -28,7 +28,11 @@ func (r *repository) CreateRatingRecord(ctx context.Context, record *ratingRecor
 			(username)
 				VALUES ($1)
 			ON CONFLICT (username) DO NOTHING
-			RETURNING id
+			RETURNING id, username, stars
+	), initial AS (
+		INSERT INTO rating_events
+			(username, delta, stars, reason)
+		SELECT username, stars, stars, $2 FROM inserted
 	)
 	SELECT id FROM inserted;`
 
@@ -36,7 +40,7 @@ func (r *repository) CreateRatingRecord(ctx context.Context, record *ratingRecor
 	defer cancel()
 
 	var id int
-	err := r.conn.QueryRowContext(ctx, insertRatingRecordQuery, *record.UserName).Scan(&id)
+	err := r.conn.QueryRowContext(ctx, insertRatingRecordQuery, *record.UserName, reasonInitial).Scan(&id)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to execute query")
 	}
@@ -44,42 +48,219 @@ func (r *repository) CreateRatingRecord(ctx context.Context, record *ratingRecor
 	return id, nil
 }
 
-func (r *repository) createUpdateBuilderForRecord(username string, record ratingRecord) (sq.UpdateBuilder, bool) {
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	isEmpty := true
-	updateBuilder := psql.Update("rating").Where(sq.Eq{"username": username})
-	if record.UserName != nil {
-		updateBuilder = updateBuilder.Set("username", record.UserName)
-		isEmpty = false
+// UpdateRatingStars атомарно изменяет рейтинг на event.Delta, ограничивая результат [minStars, maxStars],
+// записывает event в журнал и возвращает новый рейтинг. Если передана операция op, она записывается
+// в applied_operations в той же транзакции: повтор операции не применяется и возвращает
+// errOperationApplied с исходным рейтингом.
+func (r *repository) UpdateRatingStars(ctx context.Context, event ratingEvent, minStars, maxStars int, op *operation) (int, error) {
+	updateStarsQuery := `
+	UPDATE rating
+		SET stars = LEAST(GREATEST(stars + $1, $2), $3)
+		WHERE username = $4
+		RETURNING stars;`
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	if op != nil {
+		applied, err := r.insertOperation(ctx, tx, *op)
+		if err != nil {
+			return applied.Stars, err
+		}
+	}
+
+	var stars int
+	err = tx.GetContext(ctx, &stars, updateStarsQuery, event.Delta, minStars, maxStars, event.UserName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errRecordNotFound
+		}
+		return 0, errors.Wrap(err, "failed to execute query")
+	}
+
+	event.Stars = stars
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return 0, err
 	}
-	if record.Stars != nil {
-		updateBuilder = updateBuilder.Set("stars", record.Stars)
-		isEmpty = false
+
+	if op != nil {
+		_, err = tx.ExecContext(ctx, `UPDATE applied_operations SET stars = $1 WHERE operation_id = $2;`, stars, op.ID)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to save operation result")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to commit transaction")
 	}
 
-	return updateBuilder, isEmpty
+	return stars, nil
 }
 
-func (r *repository) UpdateRatingRecord(ctx context.Context, userName string, record *ratingRecord) error {
-	builder, isEmpty := r.createUpdateBuilderForRecord(userName, *record)
-	if isEmpty {
-		return nil
+func insertEvent(ctx context.Context, tx *sqlx.Tx, event ratingEvent) error {
+	query := `
+	INSERT INTO rating_events
+		(username, delta, stars, reason, reservation_uid)
+			VALUES ($1, $2, $3, $4, $5);`
+
+	_, err := tx.ExecContext(ctx, query, event.UserName, event.Delta, event.Stars, event.Reason, event.ReservationUid)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert rating event")
+	}
+	return nil
+}
+
+// RebuildRatingStars пересчитывает рейтинг по журналу, последовательно применяя изменения
+// с ограничением [minStars, maxStars], сохраняет и возвращает результат.
+func (r *repository) RebuildRatingStars(ctx context.Context, username string, minStars, maxStars int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	// блокировка записи не дает параллельным изменениям попасть между чтением журнала и сохранением
+	var current int
+	err = tx.GetContext(ctx, &current, `SELECT stars FROM rating WHERE username = $1 FOR UPDATE;`, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errRecordNotFound
+		}
+		return 0, errors.Wrap(err, "failed to execute query")
+	}
+
+	var deltas []int
+	err = tx.SelectContext(ctx, &deltas, `SELECT delta FROM rating_events WHERE username = $1 ORDER BY id;`, username)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get rating events")
+	}
+	if len(deltas) == 0 {
+		return 0, errEventsNotFound
+	}
+
+	stars := 0
+	for _, delta := range deltas {
+		stars = min(max(stars+delta, minStars), maxStars)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE rating SET stars = $1 WHERE username = $2;`, stars, username)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to execute query")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to commit transaction")
 	}
 
+	return stars, nil
+}
+
+// GetRatingEvents возвращает страницу журнала пользователя от новых событий к старым
+// и общее количество событий.
+func (r *repository) GetRatingEvents(ctx context.Context, username string, offset, limit int) ([]ratingEvent, int, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	builder := psql.Select("id", "username", "delta", "stars", "reason", "reservation_uid", "created_at").
+		From("rating_events").
+		Where(sq.Eq{"username": username}).
+		OrderBy("id DESC").
+		Offset(uint64(offset)).
+		Limit(uint64(limit))
+
 	query, args, err := builder.ToSql()
 	if err != nil {
-		return errors.Wrap(err, "failed to build query")
+		return nil, 0, errors.Wrap(err, "failed to build query")
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
-	_, err = r.conn.ExecContext(ctx, query, args...)
+	res := make([]ratingEvent, 0)
+	err = r.conn.SelectContext(ctx, &res, query, args...)
 	if err != nil {
-		return errors.Wrap(err, "failed to execute query")
+		return nil, 0, errors.Wrap(err, "failed to execute query")
 	}
 
-	return nil
+	var total int
+	err = r.conn.GetContext(ctx, &total, `SELECT count(*) FROM rating_events WHERE username = $1;`, username)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to count rating events")
+	}
+
+	return res, total, nil
+}
+
+// insertOperation записывает операцию. Если операция с тем же ID уже записана (в том числе
+// конкурентной транзакцией), возвращает ее и errOperationApplied или errOperationConflict.
+func (r *repository) insertOperation(ctx context.Context, tx *sqlx.Tx, op operation) (operation, error) {
+	query := `
+	INSERT INTO applied_operations
+		(operation_id, username, stars_diff, stars)
+			VALUES ($1, $2, $3, 0)
+		ON CONFLICT (operation_id) DO NOTHING;`
+
+	res, err := tx.ExecContext(ctx, query, op.ID, op.UserName, op.StarsDiff)
+	if err != nil {
+		return operation{}, errors.Wrap(err, "failed to insert operation")
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return operation{}, errors.Wrap(err, "failed to get rows affected")
+	}
+	if rowsAffected != 0 {
+		return operation{}, nil
+	}
+
+	applied, err := r.getOperation(ctx, tx, op.ID)
+	if err != nil {
+		return operation{}, err
+	}
+	if !applied.sameAs(op) {
+		return applied, errOperationConflict
+	}
+	return applied, errOperationApplied
+}
+
+func (r *repository) GetOperation(ctx context.Context, operationId string) (operation, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	return r.getOperation(ctx, r.conn, operationId)
+}
+
+func (r *repository) getOperation(ctx context.Context, q sqlx.QueryerContext, operationId string) (operation, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	builder := psql.Select("operation_id", "username", "stars_diff", "stars").From("applied_operations").Where(sq.Eq{"operation_id": operationId})
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return operation{}, errors.Wrap(err, "failed to build query")
+	}
+
+	res := operation{}
+	err = sqlx.GetContext(ctx, q, &res, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return operation{}, errOperationNotFound
+		}
+		return operation{}, errors.Wrap(err, "failed to execute query")
+	}
+
+	return res, nil
 }
 
 func (r *repository) GetRatingRecord(ctx context.Context, username string) (ratingRecord, error) {
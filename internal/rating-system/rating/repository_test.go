@@ -0,0 +1,93 @@
+package rating
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+// newTestRepository подключается к базе с примененными миграциями rating-system из POSTGRESQL_DSN
+func newTestRepository(t *testing.T) (*repository, *sqlx.DB) {
+	dsn := os.Getenv("POSTGRESQL_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRESQL_DSN is not set")
+	}
+
+	conn, err := sqlx.Connect("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return NewRepository(conn), conn
+}
+
+func Test_UpdateRatingStars(t *testing.T) {
+	r, conn := newTestRepository(t)
+	ctx := context.Background()
+
+	username := uuid.NewString()
+	_, err := conn.Exec(`INSERT INTO rating (username, stars) VALUES ($1, 5)`, username)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = conn.Exec(`DELETE FROM applied_operations WHERE username = $1`, username)
+		_, _ = conn.Exec(`DELETE FROM rating WHERE username = $1`, username)
+	})
+
+	op := &operation{ID: uuid.NewString(), UserName: username, StarsDiff: -10}
+	stars, err := r.UpdateRatingStars(ctx, ratingEvent{UserName: username, Delta: -10, Reason: "EXPIRED"}, 1, 100, op)
+	require.NoError(t, err)
+	require.Equal(t, 1, stars)
+
+	stars, err = r.UpdateRatingStars(ctx, ratingEvent{UserName: username, Delta: -10, Reason: "EXPIRED"}, 1, 100, op)
+	require.ErrorIs(t, err, errOperationApplied)
+	require.Equal(t, 1, stars)
+
+	stars, err = r.UpdateRatingStars(ctx, ratingEvent{UserName: username, Delta: 500, Reason: reasonUnspecified}, 1, 100, nil)
+	require.NoError(t, err)
+	require.Equal(t, 100, stars)
+
+	_, err = r.UpdateRatingStars(ctx, ratingEvent{UserName: uuid.NewString(), Delta: 1, Reason: reasonUnspecified}, 1, 100, nil)
+	require.ErrorIs(t, err, errRecordNotFound)
+
+	events, total, err := r.GetRatingEvents(ctx, username, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	require.Equal(t, []int{500, -10}, []int{events[0].Delta, events[1].Delta})
+	require.Equal(t, []int{100, 1}, []int{events[0].Stars, events[1].Stars})
+}
+
+func Test_RebuildRatingStars(t *testing.T) {
+	r, conn := newTestRepository(t)
+	ctx := context.Background()
+
+	username := uuid.NewString()
+	_, err := conn.Exec(`INSERT INTO rating (username, stars) VALUES ($1, 5)`, username)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = conn.Exec(`DELETE FROM rating_events WHERE username = $1`, username)
+		_, _ = conn.Exec(`DELETE FROM rating WHERE username = $1`, username)
+	})
+
+	_, err = r.RebuildRatingStars(ctx, username, 1, 100)
+	require.ErrorIs(t, err, errEventsNotFound)
+
+	_, err = conn.Exec(`INSERT INTO rating_events (username, delta, stars, reason) VALUES ($1, 5, 5, $2)`, username, reasonInitial)
+	require.NoError(t, err)
+	_, err = r.UpdateRatingStars(ctx, ratingEvent{UserName: username, Delta: -10, Reason: "EXPIRED"}, 1, 100, nil)
+	require.NoError(t, err)
+	_, err = r.UpdateRatingStars(ctx, ratingEvent{UserName: username, Delta: 3, Reason: "RETURNED"}, 1, 100, nil)
+	require.NoError(t, err)
+
+	_, err = conn.Exec(`UPDATE rating SET stars = 50 WHERE username = $1`, username)
+	require.NoError(t, err)
+
+	stars, err := r.RebuildRatingStars(ctx, username, 1, 100)
+	require.NoError(t, err)
+	require.Equal(t, 4, stars)
+
+	_, err = r.RebuildRatingStars(ctx, uuid.NewString(), 1, 100)
+	require.ErrorIs(t, err, errRecordNotFound)
+}
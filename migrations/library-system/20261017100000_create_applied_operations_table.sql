@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE applied_operations
+(
+    operation_id VARCHAR(255) PRIMARY KEY,
+    library_uid  uuid      NOT NULL,
+    book_uid     uuid      NOT NULL,
+    count_diff   INT       NOT NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS applied_operations;
+-- +goose StatementEnd
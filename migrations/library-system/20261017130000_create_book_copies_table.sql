@@ -0,0 +1,58 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE book_copies
+(
+    id              SERIAL PRIMARY KEY,
+    copy_uid        uuid UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+    book_id         INT         NOT NULL REFERENCES books (id),
+    library_id      INT         NOT NULL REFERENCES library (id),
+    condition       VARCHAR(20) NOT NULL DEFAULT 'EXCELLENT'
+        CHECK (condition IN ('EXCELLENT', 'GOOD', 'BAD')),
+    status          VARCHAR(20) NOT NULL DEFAULT 'AVAILABLE'
+        CHECK (status IN ('AVAILABLE', 'RENTED')),
+    reservation_uid uuid
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX book_copies_library_book_idx ON book_copies (library_id, book_id, status);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX book_copies_reservation_idx ON book_copies (reservation_uid);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- выданные до миграции экземпляры неизвестны, создаются только доступные, выданные добавляются при возврате
+INSERT INTO book_copies (book_id, library_id, condition)
+SELECT lb.book_id, lb.library_id, COALESCE(b.condition, 'EXCELLENT')
+FROM library_books lb
+         JOIN books b ON b.id = lb.book_id
+         CROSS JOIN generate_series(1, lb.available_count);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE library_books DROP COLUMN available_count;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE library_books ADD COLUMN available_count INT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE library_books lb
+SET available_count = (SELECT count(*)
+                       FROM book_copies c
+                       WHERE c.library_id = lb.library_id
+                         AND c.book_id = lb.book_id
+                         AND c.status = 'AVAILABLE');
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE library_books ALTER COLUMN available_count DROP DEFAULT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS book_copies;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE library ADD COLUMN deleted_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE books ADD COLUMN deleted_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE library_books ADD COLUMN deleted_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX library_books_library_book_idx ON library_books (library_id, book_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS library_books_library_book_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE library_books DROP COLUMN deleted_at;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE books DROP COLUMN deleted_at;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE library DROP COLUMN deleted_at;
+-- +goose StatementEnd
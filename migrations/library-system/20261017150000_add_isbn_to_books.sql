@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE books ADD COLUMN isbn VARCHAR(17) UNIQUE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE books DROP COLUMN isbn;
+-- +goose StatementEnd
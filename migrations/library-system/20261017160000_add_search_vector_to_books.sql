@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE books
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(author, '') || ' ' || coalesce(genre, ''))
+        ) STORED;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX books_search_vector_idx ON books USING GIN (search_vector);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS books_search_vector_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE books DROP COLUMN search_vector;
+-- +goose StatementEnd
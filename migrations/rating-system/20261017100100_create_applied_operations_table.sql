@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE applied_operations
+(
+    operation_id VARCHAR(255) PRIMARY KEY,
+    username     VARCHAR(80) NOT NULL,
+    stars_diff   INT         NOT NULL,
+    created_at   TIMESTAMP   NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS applied_operations;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE applied_operations
+    ADD COLUMN stars INT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- для уже примененных операций исходный результат не сохранялся, используется текущий рейтинг
+UPDATE applied_operations ao
+SET stars = r.stars
+FROM rating r
+WHERE r.username = ao.username;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE applied_operations
+SET stars = 0
+WHERE stars IS NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE applied_operations
+    ALTER COLUMN stars SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE applied_operations
+    DROP COLUMN IF EXISTS stars;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE rating_events
+(
+    id              SERIAL PRIMARY KEY,
+    username        VARCHAR(80) NOT NULL,
+    delta           INT         NOT NULL,
+    stars           INT         NOT NULL,
+    reason          VARCHAR(40) NOT NULL,
+    reservation_uid uuid,
+    created_at      TIMESTAMP   NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX rating_events_username_idx ON rating_events (username, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- история до появления журнала неизвестна, текущий рейтинг записывается начальным событием
+INSERT INTO rating_events (username, delta, stars, reason)
+SELECT username, stars, stars, 'INITIAL'
+FROM rating;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS rating_events;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+const bearerPrefix = "Bearer "
+
+// AdminToken пропускает только запросы с заголовком "Authorization: Bearer <token>".
+// Пустой token запрещает все запросы: без настроенного токена административный API недоступен.
+func AdminToken(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			provided, ok := strings.CutPrefix(header, bearerPrefix)
+			if token == "" || !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, echo.Map{"message": "unauthorized"})
+			}
+			return next(c)
+		}
+	}
+}
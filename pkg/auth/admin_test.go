@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_AdminToken(t *testing.T) {
+	var tests = []struct {
+		name         string
+		token        string
+		header       string
+		expectedCode int
+	}{
+		{name: "valid token", token: "secret", header: "Bearer secret", expectedCode: http.StatusOK},
+		{name: "wrong token", token: "secret", header: "Bearer other", expectedCode: http.StatusUnauthorized},
+		{name: "no bearer prefix", token: "secret", header: "secret", expectedCode: http.StatusUnauthorized},
+		{name: "no header", token: "secret", expectedCode: http.StatusUnauthorized},
+		{name: "token is not configured", token: "", header: "Bearer ", expectedCode: http.StatusUnauthorized},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			if tt.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			err := AdminToken(tt.token)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})(c)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedCode, rec.Code)
+		})
+	}
+}
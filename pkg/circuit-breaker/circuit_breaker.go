@@ -2,68 +2,400 @@ package circuit_breaker
 
 import (
 	"errors"
-	"go.uber.org/atomic"
+	"math/rand"
+	"sync"
 	"time"
 )
 
+type State string
+
+const (
+	StateClosed   State = "closed"
+	StateOpen     State = "open"
+	StateHalfOpen State = "half_open"
+)
+
+// Outcome - результат вызова через breaker, передается в OnCall-наблюдатели.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	// OutcomeIgnored - операция вернула ошибку, которую классификатор не считает отказом сервиса
+	OutcomeIgnored Outcome = "ignored"
+	OutcomeFailure Outcome = "failure"
+	// OutcomeRejected - вызов отклонен breaker'ом без выполнения операции
+	OutcomeRejected Outcome = "rejected"
+)
+
 const (
-	stateClosed   = "closed"
-	stateOpen     = "open"
-	stateHalfOpen = "half_open"
+	defaultHalfOpenMaxCalls = 1
 )
 
 var (
 	ErrCircuitBreakerOpen = errors.New("circuit breaker is open")
+	ErrTooManyRequests    = errors.New("circuit breaker is half-open: too many requests")
 )
 
+type Option func(cb *circuitBreaker)
+
+// WithHalfOpenMaxCalls задает количество пробных вызовов, которые пропускаются в состоянии half-open.
+// Breaker закрывается только после того, как все пробные вызовы завершились успешно.
+func WithHalfOpenMaxCalls(n uint64) Option {
+	return func(cb *circuitBreaker) {
+		if n > 0 {
+			cb.halfOpenMaxCalls = n
+		}
+	}
+}
+
+// WithCountWindow переключает breaker в режим доли неудач по последним size вызовам.
+// Breaker открывается, когда доля неудач достигает threshold и в окне не меньше minimumRequests вызовов.
+func WithCountWindow(size uint64, threshold float64, minimumRequests uint64) Option {
+	return func(cb *circuitBreaker) {
+		cb.counter = newCountWindow(size, threshold, minimumRequests)
+	}
+}
+
+// WithTimeWindow переключает breaker в режим доли неудач по вызовам за последние duration.
+func WithTimeWindow(duration time.Duration, threshold float64, minimumRequests uint64) Option {
+	return func(cb *circuitBreaker) {
+		cb.counter = newTimeWindow(duration, threshold, minimumRequests)
+	}
+}
+
+// WithIsFailure задает классификатор ошибок: ошибки, для которых isFailure возвращает false,
+// возвращаются вызывающему как есть, но не учитываются как отказ защищаемого сервиса.
+func WithIsFailure(isFailure func(err error) bool) Option {
+	return func(cb *circuitBreaker) {
+		if isFailure != nil {
+			cb.isFailure = isFailure
+		}
+	}
+}
+
+func isAnyError(err error) bool {
+	return err != nil
+}
+
+// WithBackoff включает экспоненциальный рост интервала open: после каждой неудачной пробы интервал
+// умножается на multiplier, но не превышает maxResetTimeout. К интервалу добавляется случайное
+// отклонение в пределах ±jitter от его величины. После успешной пробы интервал возвращается к resetTimeout.
+func WithBackoff(multiplier float64, maxResetTimeout time.Duration, jitter float64) Option {
+	return func(cb *circuitBreaker) {
+		if multiplier >= 1 {
+			cb.backoffMultiplier = multiplier
+		}
+		cb.maxResetTimeout = maxResetTimeout
+		cb.jitter = jitter
+	}
+}
+
+// WithName задает имя breaker'а, которое передается наблюдателям.
+func WithName(name string) Option {
+	return func(cb *circuitBreaker) {
+		cb.name = name
+	}
+}
+
+// WithOnStateChange добавляет наблюдателя за сменой состояния.
+// Наблюдатели вызываются синхронно под блокировкой breaker'а и не должны вызывать его методы.
+func WithOnStateChange(onStateChange func(name string, from, to State)) Option {
+	return func(cb *circuitBreaker) {
+		cb.onStateChange = append(cb.onStateChange, onStateChange)
+	}
+}
+
+// WithOnCall добавляет наблюдателя за вызовами. Для отклоненных вызовов latency равна нулю.
+func WithOnCall(onCall func(name string, outcome Outcome, latency time.Duration)) Option {
+	return func(cb *circuitBreaker) {
+		cb.onCall = append(cb.onCall, onCall)
+	}
+}
+
+// Snapshot - состояние breaker'а на момент вызова Snapshot.
+type Snapshot struct {
+	Name  string
+	State State
+	// Forced - состояние задано вручную через ForceOpen/ForceClose и не меняется само
+	Forced bool
+	// Requests и Failures - статистика текущего состояния, по которой принимается решение об открытии
+	Requests        uint64
+	Failures        uint64
+	TotalSuccesses  uint64
+	TotalFailures   uint64
+	TotalRejections uint64
+	// OpenTimeout - текущий интервал open без учета jitter
+	OpenTimeout time.Duration
+	// NextProbeAt - время перехода в half-open, нулевое, если breaker не открыт или открыт вручную
+	NextProbeAt time.Time
+}
+
 type circuitBreaker struct {
-	state         string
-	failureCount  *atomic.Uint64
-	maxFailures   uint64
-	resetTimeout  time.Duration
-	halfOpenTimer *time.Timer
-}
-
-func New(maxFailures uint64, resetTimeout time.Duration) *circuitBreaker {
-	return &circuitBreaker{
-		state:        stateClosed,
-		failureCount: atomic.NewUint64(0),
-		maxFailures:  maxFailures,
-		resetTimeout: resetTimeout,
-	}
-}
-
-func (cb *circuitBreaker) Call(operation func() error) error {
-	switch cb.state {
-	case stateOpen:
-		return ErrCircuitBreakerOpen
-	case stateHalfOpen, stateClosed:
-		err := operation()
-		if err != nil {
-			cb.recordFailure()
-			return err
+	mu                sync.Mutex
+	name              string
+	state             State
+	forced            bool
+	generation        uint64
+	counter           counter
+	halfOpenCalls     uint64
+	halfOpenSuccesses uint64
+	halfOpenMaxCalls  uint64
+	resetTimeout      time.Duration
+	maxResetTimeout   time.Duration
+	backoffMultiplier float64
+	jitter            float64
+	openTimeout       time.Duration
+	openUntil         time.Time
+	totalSuccesses    uint64
+	totalFailures     uint64
+	totalRejections   uint64
+	isFailure         func(err error) bool
+	onStateChange     []func(name string, from, to State)
+	onCall            []func(name string, outcome Outcome, latency time.Duration)
+	now               func() time.Time
+	random            func() float64
+}
+
+func New(maxFailures uint64, resetTimeout time.Duration, opts ...Option) *circuitBreaker {
+	cb := &circuitBreaker{
+		state:             StateClosed,
+		counter:           newConsecutiveCounter(maxFailures),
+		halfOpenMaxCalls:  defaultHalfOpenMaxCalls,
+		resetTimeout:      resetTimeout,
+		backoffMultiplier: 1,
+		isFailure:         isAnyError,
+		now:               time.Now,
+		random:            rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	return cb
+}
+
+func (cb *circuitBreaker) Call(operation func() error) (err error) {
+	generation, err := cb.beforeCall()
+	if err != nil {
+		cb.notifyCall(OutcomeRejected, 0)
+		return err
+	}
+
+	start := time.Now()
+	// паника в operation не должна оставлять занятым слот пробного вызова
+	defer func() {
+		if e := recover(); e != nil {
+			cb.afterCall(generation, false)
+			cb.notifyCall(OutcomeFailure, time.Since(start))
+			panic(e)
+		}
+	}()
+
+	err = operation()
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeFailure
+		if !cb.isFailure(err) {
+			outcome = OutcomeIgnored
+		}
+	}
+	cb.afterCall(generation, outcome != OutcomeFailure)
+	cb.notifyCall(outcome, time.Since(start))
+	return err
+}
+
+// CallWithFallback выполняет operation через breaker и, если вызов завершился ошибкой или был отклонен,
+// возвращает результат fallback(err). Nil-fallback возвращает ошибку вызова как есть.
+func (cb *circuitBreaker) CallWithFallback(operation func() error, fallback func(err error) error) error {
+	err := cb.Call(operation)
+	if err == nil || fallback == nil {
+		return err
+	}
+	return fallback(err)
+}
+
+func (cb *circuitBreaker) Name() string {
+	return cb.name
+}
+
+func (cb *circuitBreaker) State() State {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state, _ := cb.currentState(cb.now())
+	return state
+}
+
+func (cb *circuitBreaker) Snapshot() Snapshot {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := cb.now()
+	state, _ := cb.currentState(now)
+	requests, failures := cb.counter.counts(now)
+	return Snapshot{
+		Name:            cb.name,
+		State:           state,
+		Forced:          cb.forced,
+		Requests:        requests,
+		Failures:        failures,
+		TotalSuccesses:  cb.totalSuccesses,
+		TotalFailures:   cb.totalFailures,
+		TotalRejections: cb.totalRejections,
+		OpenTimeout:     cb.openTimeout,
+		NextProbeAt:     cb.openUntil,
+	}
+}
+
+// ForceOpen открывает breaker до вызова ForceClose или Reset: все вызовы отклоняются, пробы не выполняются.
+func (cb *circuitBreaker) ForceOpen() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.force(StateOpen)
+}
+
+// ForceClose закрывает breaker до вызова ForceOpen или Reset: все вызовы пропускаются, отказы не открывают breaker.
+func (cb *circuitBreaker) ForceClose() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.force(StateClosed)
+}
+
+// Reset снимает ручное управление и возвращает breaker в closed с обнуленной статистикой.
+func (cb *circuitBreaker) Reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.forced = false
+	cb.transitionTo(StateClosed, cb.now())
+	cb.counter.reset()
+}
+
+func (cb *circuitBreaker) force(state State) {
+	cb.transitionTo(state, cb.now())
+	cb.forced = true
+	cb.openUntil = time.Time{}
+}
+
+func (cb *circuitBreaker) notifyCall(outcome Outcome, latency time.Duration) {
+	for _, onCall := range cb.onCall {
+		onCall(cb.name, outcome, latency)
+	}
+}
+
+func (cb *circuitBreaker) beforeCall() (uint64, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state, generation := cb.currentState(cb.now())
+	switch state {
+	case StateOpen:
+		cb.totalRejections++
+		return generation, ErrCircuitBreakerOpen
+	case StateHalfOpen:
+		if cb.halfOpenCalls >= cb.halfOpenMaxCalls {
+			cb.totalRejections++
+			return generation, ErrTooManyRequests
 		}
-		cb.reset()
-		return nil
+		cb.halfOpenCalls++
 	}
-	return nil
+	return generation, nil
 }
 
-func (cb *circuitBreaker) recordFailure() {
-	fCount := cb.failureCount.Inc()
-	if fCount >= cb.maxFailures {
-		cb.transitionTo(stateOpen)
-		cb.halfOpenTimer = time.AfterFunc(cb.resetTimeout, func() {
-			cb.transitionTo(stateHalfOpen)
-		})
+func (cb *circuitBreaker) afterCall(before uint64, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := cb.now()
+	state, generation := cb.currentState(now)
+	// результат вызова, начатого до смены состояния, не учитываем
+	if generation != before {
+		return
+	}
+
+	if success {
+		cb.totalSuccesses++
+		cb.recordSuccess(state, now)
+		return
 	}
+	cb.totalFailures++
+	cb.recordFailure(state, now)
 }
 
-func (cb *circuitBreaker) reset() {
-	cb.failureCount = atomic.NewUint64(0)
-	cb.transitionTo(stateClosed)
+func (cb *circuitBreaker) recordSuccess(state State, now time.Time) {
+	switch state {
+	case StateClosed:
+		cb.counter.onSuccess(now)
+	case StateHalfOpen:
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= cb.halfOpenMaxCalls {
+			cb.transitionTo(StateClosed, now)
+		}
+	}
+}
+
+func (cb *circuitBreaker) recordFailure(state State, now time.Time) {
+	switch state {
+	case StateClosed:
+		cb.counter.onFailure(now)
+		if !cb.forced && cb.counter.shouldTrip(now) {
+			cb.transitionTo(StateOpen, now)
+		}
+	case StateHalfOpen:
+		// неудачная проба сразу возвращает breaker в open
+		cb.transitionTo(StateOpen, now)
+	}
+}
+
+func (cb *circuitBreaker) currentState(now time.Time) (State, uint64) {
+	if cb.state == StateOpen && !cb.forced && !now.Before(cb.openUntil) {
+		cb.transitionTo(StateHalfOpen, now)
+	}
+	return cb.state, cb.generation
 }
 
-func (cb *circuitBreaker) transitionTo(state string) {
+func (cb *circuitBreaker) transitionTo(state State, now time.Time) {
+	if cb.state == state {
+		return
+	}
+
+	from := cb.state
 	cb.state = state
+	cb.forced = false
+	cb.generation++
+	cb.counter.reset()
+	cb.halfOpenCalls = 0
+	cb.halfOpenSuccesses = 0
+	cb.openUntil = time.Time{}
+	switch state {
+	case StateOpen:
+		cb.openTimeout = cb.nextOpenTimeout(from)
+		cb.openUntil = now.Add(cb.withJitter(cb.openTimeout))
+	case StateClosed:
+		cb.openTimeout = 0
+	}
+
+	for _, onStateChange := range cb.onStateChange {
+		onStateChange(cb.name, from, state)
+	}
+}
+
+func (cb *circuitBreaker) nextOpenTimeout(from State) time.Duration {
+	if from != StateHalfOpen || cb.openTimeout == 0 {
+		return cb.resetTimeout
+	}
+
+	timeout := time.Duration(float64(cb.openTimeout) * cb.backoffMultiplier)
+	if cb.maxResetTimeout > 0 && timeout > cb.maxResetTimeout {
+		timeout = cb.maxResetTimeout
+	}
+	return timeout
+}
+
+func (cb *circuitBreaker) withJitter(timeout time.Duration) time.Duration {
+	if cb.jitter <= 0 {
+		return timeout
+	}
+	return timeout + time.Duration(float64(timeout)*cb.jitter*(2*cb.random()-1))
 }
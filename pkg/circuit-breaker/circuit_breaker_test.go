@@ -0,0 +1,461 @@
+package circuit_breaker
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errOperation = errors.New("operation error")
+
+type clockStub struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clockStub) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clockStub) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCircuitBreaker(maxFailures uint64, resetTimeout time.Duration, opts ...Option) (*circuitBreaker, *clockStub) {
+	clock := &clockStub{now: time.Unix(0, 0)}
+	cb := New(maxFailures, resetTimeout, opts...)
+	cb.now = clock.Now
+	return cb, clock
+}
+
+func succeed() error { return nil }
+
+func fail() error { return errOperation }
+
+func Test_CircuitBreakerStates(t *testing.T) {
+	tests := []struct {
+		name          string
+		calls         []func() error
+		elapsed       time.Duration
+		expectedState State
+	}{
+		{
+			name:          "closed: failures below threshold",
+			calls:         []func() error{fail, fail},
+			expectedState: StateClosed,
+		},
+		{
+			name:          "closed: success resets consecutive failures",
+			calls:         []func() error{fail, fail, succeed, fail, fail},
+			expectedState: StateClosed,
+		},
+		{
+			name:          "open: threshold reached",
+			calls:         []func() error{fail, fail, fail},
+			expectedState: StateOpen,
+		},
+		{
+			name:          "half-open: reset timeout elapsed",
+			calls:         []func() error{fail, fail, fail},
+			elapsed:       time.Second,
+			expectedState: StateHalfOpen,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, clock := newTestCircuitBreaker(3, time.Second)
+			for _, call := range tt.calls {
+				_ = cb.Call(call)
+			}
+			clock.Add(tt.elapsed)
+
+			require.Equal(t, tt.expectedState, cb.State())
+		})
+	}
+}
+
+func Test_CircuitBreakerOpenRejectsCalls(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(1, time.Second)
+	require.ErrorIs(t, cb.Call(fail), errOperation)
+
+	called := false
+	err := cb.Call(func() error {
+		called = true
+		return nil
+	})
+
+	require.ErrorIs(t, err, ErrCircuitBreakerOpen)
+	require.False(t, called)
+}
+
+func Test_CircuitBreakerHalfOpenProbe(t *testing.T) {
+	t.Run("failed probe reopens immediately", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Second, WithHalfOpenMaxCalls(2))
+		_ = cb.Call(fail)
+		clock.Add(time.Second)
+
+		require.ErrorIs(t, cb.Call(fail), errOperation)
+		require.Equal(t, StateOpen, cb.State())
+		require.ErrorIs(t, cb.Call(succeed), ErrCircuitBreakerOpen)
+	})
+
+	t.Run("closes after all probes succeeded", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Second, WithHalfOpenMaxCalls(2))
+		_ = cb.Call(fail)
+		clock.Add(time.Second)
+
+		require.NoError(t, cb.Call(succeed))
+		require.Equal(t, StateHalfOpen, cb.State())
+		require.NoError(t, cb.Call(succeed))
+		require.Equal(t, StateClosed, cb.State())
+	})
+
+	t.Run("admits only configured number of concurrent probes", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Second, WithHalfOpenMaxCalls(1))
+		_ = cb.Call(fail)
+		clock.Add(time.Second)
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- cb.Call(func() error {
+				close(started)
+				<-release
+				return nil
+			})
+		}()
+		<-started
+
+		require.ErrorIs(t, cb.Call(succeed), ErrTooManyRequests)
+
+		close(release)
+		require.NoError(t, <-done)
+		require.Equal(t, StateClosed, cb.State())
+	})
+}
+
+func Test_CircuitBreakerPanicReleasesProbe(t *testing.T) {
+	cb, clock := newTestCircuitBreaker(1, time.Second)
+	_ = cb.Call(fail)
+	clock.Add(time.Second)
+
+	require.Panics(t, func() {
+		_ = cb.Call(func() error { panic("test") })
+	})
+	require.Equal(t, StateOpen, cb.State())
+}
+
+func Test_CircuitBreakerConcurrentCalls(t *testing.T) {
+	cb := New(5, time.Millisecond, WithHalfOpenMaxCalls(3))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if (i+j)%3 == 0 {
+					_ = cb.Call(fail)
+				} else {
+					_ = cb.Call(succeed)
+				}
+				_ = cb.State()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.Contains(t, []State{StateClosed, StateOpen, StateHalfOpen}, cb.State())
+}
+
+func Test_CircuitBreakerCountWindow(t *testing.T) {
+	tests := []struct {
+		name          string
+		calls         []func() error
+		expectedState State
+	}{
+		{
+			name:          "closed: below minimum requests",
+			calls:         []func() error{fail, fail, fail},
+			expectedState: StateClosed,
+		},
+		{
+			name:          "closed: failure rate below threshold",
+			calls:         []func() error{succeed, succeed, fail, succeed, succeed, fail},
+			expectedState: StateClosed,
+		},
+		{
+			name:          "open: flapping upstream reaches threshold",
+			calls:         []func() error{succeed, fail, succeed, fail},
+			expectedState: StateOpen,
+		},
+		{
+			name:          "closed: old failures slide out of window",
+			calls:         []func() error{fail, fail, succeed, succeed, succeed, succeed, succeed, succeed},
+			expectedState: StateClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, _ := newTestCircuitBreaker(1, time.Second, WithCountWindow(6, 0.5, 4))
+			for _, call := range tt.calls {
+				_ = cb.Call(call)
+			}
+
+			require.Equal(t, tt.expectedState, cb.State())
+		})
+	}
+}
+
+func Test_CircuitBreakerTimeWindow(t *testing.T) {
+	t.Run("open: failure rate within window", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Minute, WithTimeWindow(10*time.Second, 0.5, 4))
+		for _, call := range []func() error{succeed, fail, succeed, fail} {
+			_ = cb.Call(call)
+			clock.Add(time.Second)
+		}
+
+		require.Equal(t, StateOpen, cb.State())
+	})
+
+	t.Run("closed: failures expired from window", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Second, WithTimeWindow(10*time.Second, 0.5, 4))
+		_ = cb.Call(fail)
+		_ = cb.Call(fail)
+		clock.Add(11 * time.Second)
+		for _, call := range []func() error{succeed, succeed, fail} {
+			_ = cb.Call(call)
+		}
+
+		require.Equal(t, StateClosed, cb.State())
+	})
+}
+
+func Test_CircuitBreakerIsFailure(t *testing.T) {
+	errClient := errors.New("client error")
+	isFailure := func(err error) bool {
+		return !errors.Is(err, errClient)
+	}
+
+	t.Run("ignored errors do not trip", func(t *testing.T) {
+		cb, _ := newTestCircuitBreaker(2, time.Second, WithIsFailure(isFailure))
+		for i := 0; i < 5; i++ {
+			require.ErrorIs(t, cb.Call(func() error { return errClient }), errClient)
+		}
+
+		require.Equal(t, StateClosed, cb.State())
+	})
+
+	t.Run("ignored errors reset consecutive failures", func(t *testing.T) {
+		cb, _ := newTestCircuitBreaker(2, time.Second, WithIsFailure(isFailure))
+		_ = cb.Call(fail)
+		_ = cb.Call(func() error { return errClient })
+		_ = cb.Call(fail)
+
+		require.Equal(t, StateClosed, cb.State())
+	})
+
+	t.Run("ignored error closes half-open breaker", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Second, WithIsFailure(isFailure))
+		_ = cb.Call(fail)
+		clock.Add(time.Second)
+		_ = cb.Call(func() error { return errClient })
+
+		require.Equal(t, StateClosed, cb.State())
+	})
+}
+
+func Test_CircuitBreakerObservers(t *testing.T) {
+	type transition struct {
+		from, to State
+	}
+	var transitions []transition
+	var outcomes []Outcome
+	errIgnored := errors.New("ignored")
+
+	cb, clock := newTestCircuitBreaker(1, time.Second,
+		WithName("test"),
+		WithIsFailure(func(err error) bool { return !errors.Is(err, errIgnored) }),
+		WithOnStateChange(func(name string, from, to State) {
+			require.Equal(t, "test", name)
+			transitions = append(transitions, transition{from: from, to: to})
+		}),
+		WithOnCall(func(name string, outcome Outcome, latency time.Duration) {
+			require.Equal(t, "test", name)
+			outcomes = append(outcomes, outcome)
+		}),
+	)
+
+	_ = cb.Call(succeed)
+	_ = cb.Call(func() error { return errIgnored })
+	_ = cb.Call(fail)
+	_ = cb.Call(succeed)
+	clock.Add(time.Second)
+	_ = cb.Call(succeed)
+
+	require.Equal(t, []Outcome{OutcomeSuccess, OutcomeIgnored, OutcomeFailure, OutcomeRejected, OutcomeSuccess}, outcomes)
+	require.Equal(t, []transition{
+		{from: StateClosed, to: StateOpen},
+		{from: StateOpen, to: StateHalfOpen},
+		{from: StateHalfOpen, to: StateClosed},
+	}, transitions)
+}
+
+func Test_CircuitBreakerForce(t *testing.T) {
+	t.Run("forced open rejects calls and does not probe", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Second)
+		cb.ForceOpen()
+		clock.Add(time.Hour)
+
+		require.ErrorIs(t, cb.Call(succeed), ErrCircuitBreakerOpen)
+		snapshot := cb.Snapshot()
+		require.Equal(t, StateOpen, snapshot.State)
+		require.True(t, snapshot.Forced)
+		require.True(t, snapshot.NextProbeAt.IsZero())
+		require.Equal(t, uint64(1), snapshot.TotalRejections)
+	})
+
+	t.Run("forced closed ignores failures", func(t *testing.T) {
+		cb, _ := newTestCircuitBreaker(1, time.Second)
+		cb.ForceClose()
+		for i := 0; i < 3; i++ {
+			_ = cb.Call(fail)
+		}
+
+		snapshot := cb.Snapshot()
+		require.Equal(t, StateClosed, snapshot.State)
+		require.Equal(t, uint64(3), snapshot.Failures)
+		require.Equal(t, uint64(3), snapshot.TotalFailures)
+	})
+
+	t.Run("reset returns to automatic mode", func(t *testing.T) {
+		cb, _ := newTestCircuitBreaker(1, time.Second)
+		cb.ForceOpen()
+		cb.Reset()
+
+		require.NoError(t, cb.Call(succeed))
+		require.ErrorIs(t, cb.Call(fail), errOperation)
+		snapshot := cb.Snapshot()
+		require.Equal(t, StateOpen, snapshot.State)
+		require.False(t, snapshot.Forced)
+		require.False(t, snapshot.NextProbeAt.IsZero())
+	})
+}
+
+func Test_CircuitBreakerBackoff(t *testing.T) {
+	t.Run("failed probes grow open interval up to cap", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Second, WithBackoff(2, 5*time.Second, 0))
+		_ = cb.Call(fail)
+
+		expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+		for _, timeout := range expected {
+			snapshot := cb.Snapshot()
+			require.Equal(t, timeout, snapshot.OpenTimeout)
+			require.Equal(t, clock.Now().Add(timeout), snapshot.NextProbeAt)
+
+			clock.Add(timeout)
+			_ = cb.Call(fail)
+		}
+	})
+
+	t.Run("successful probe shrinks open interval back", func(t *testing.T) {
+		cb, clock := newTestCircuitBreaker(1, time.Second, WithBackoff(2, time.Minute, 0))
+		_ = cb.Call(fail)
+		clock.Add(time.Second)
+		_ = cb.Call(fail)
+		clock.Add(2 * time.Second)
+		_ = cb.Call(succeed)
+		require.Equal(t, StateClosed, cb.State())
+
+		_ = cb.Call(fail)
+
+		require.Equal(t, time.Second, cb.Snapshot().OpenTimeout)
+	})
+
+	t.Run("jitter stays within bounds", func(t *testing.T) {
+		for _, random := range []float64{0, 0.5, 0.999} {
+			cb, clock := newTestCircuitBreaker(1, 10*time.Second, WithBackoff(2, time.Minute, 0.2))
+			cb.random = func() float64 { return random }
+			_ = cb.Call(fail)
+
+			delay := cb.Snapshot().NextProbeAt.Sub(clock.Now())
+			require.GreaterOrEqual(t, delay, 8*time.Second)
+			require.LessOrEqual(t, delay, 12*time.Second)
+		}
+	})
+}
+
+func Test_CircuitBreakerCallWithFallback(t *testing.T) {
+	errFallback := errors.New("fallback error")
+	tests := []struct {
+		name        string
+		operation   func() error
+		fallback    func(err error) error
+		open        bool
+		expectedErr error
+		fallbackErr error
+	}{
+		{
+			name:      "success: fallback not called",
+			operation: succeed,
+			fallback: func(err error) error {
+				t.Fatal("fallback must not be called")
+				return nil
+			},
+		},
+		{
+			name:        "failure: fallback receives operation error",
+			operation:   fail,
+			fallback:    func(err error) error { return errFallback },
+			expectedErr: errFallback,
+			fallbackErr: errOperation,
+		},
+		{
+			name:        "rejected: fallback receives breaker error",
+			operation:   succeed,
+			fallback:    func(err error) error { return nil },
+			open:        true,
+			fallbackErr: ErrCircuitBreakerOpen,
+		},
+		{
+			name:        "nil fallback: operation error returned",
+			operation:   fail,
+			expectedErr: errOperation,
+			fallbackErr: errOperation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, _ := newTestCircuitBreaker(1, time.Minute)
+			if tt.open {
+				cb.ForceOpen()
+			}
+
+			var fallbackErr error
+			fallback := tt.fallback
+			if fallback != nil {
+				fallback = func(err error) error {
+					fallbackErr = err
+					return tt.fallback(err)
+				}
+			}
+
+			err := cb.CallWithFallback(tt.operation, fallback)
+
+			require.Equal(t, tt.expectedErr, err)
+			if tt.fallback != nil {
+				require.Equal(t, tt.fallbackErr, fallbackErr)
+			}
+		})
+	}
+}
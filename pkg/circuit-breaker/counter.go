@@ -0,0 +1,189 @@
+package circuit_breaker
+
+import (
+	"time"
+)
+
+// counter накапливает статистику вызовов в состоянии closed и решает, пора ли открывать breaker.
+type counter interface {
+	onSuccess(now time.Time)
+	onFailure(now time.Time)
+	shouldTrip(now time.Time) bool
+	counts(now time.Time) (requests, failures uint64)
+	reset()
+}
+
+// consecutiveCounter открывает breaker после maxFailures неудач подряд, любой успех обнуляет счетчик.
+type consecutiveCounter struct {
+	maxFailures uint64
+	requests    uint64
+	failures    uint64
+}
+
+func newConsecutiveCounter(maxFailures uint64) *consecutiveCounter {
+	return &consecutiveCounter{maxFailures: maxFailures}
+}
+
+func (c *consecutiveCounter) onSuccess(time.Time) {
+	c.requests++
+	c.failures = 0
+}
+
+func (c *consecutiveCounter) onFailure(time.Time) {
+	c.requests++
+	c.failures++
+}
+
+func (c *consecutiveCounter) shouldTrip(time.Time) bool {
+	return c.failures >= c.maxFailures
+}
+
+func (c *consecutiveCounter) counts(time.Time) (uint64, uint64) {
+	return c.requests, c.failures
+}
+
+func (c *consecutiveCounter) reset() {
+	c.requests = 0
+	c.failures = 0
+}
+
+// countWindow открывает breaker, если доля неудач среди последних size вызовов достигла порога.
+type countWindow struct {
+	outcomes        []bool
+	next            int
+	filled          int
+	failures        uint64
+	threshold       float64
+	minimumRequests uint64
+}
+
+func newCountWindow(size uint64, threshold float64, minimumRequests uint64) *countWindow {
+	if size == 0 {
+		size = 1
+	}
+	return &countWindow{
+		outcomes:        make([]bool, size),
+		threshold:       threshold,
+		minimumRequests: minimumRequests,
+	}
+}
+
+func (w *countWindow) onSuccess(time.Time) {
+	w.record(false)
+}
+
+func (w *countWindow) onFailure(time.Time) {
+	w.record(true)
+}
+
+func (w *countWindow) record(failed bool) {
+	if w.filled == len(w.outcomes) {
+		if w.outcomes[w.next] {
+			w.failures--
+		}
+	} else {
+		w.filled++
+	}
+
+	w.outcomes[w.next] = failed
+	if failed {
+		w.failures++
+	}
+	w.next = (w.next + 1) % len(w.outcomes)
+}
+
+func (w *countWindow) shouldTrip(time.Time) bool {
+	return tripByRate(uint64(w.filled), w.failures, w.threshold, w.minimumRequests)
+}
+
+func (w *countWindow) counts(time.Time) (uint64, uint64) {
+	return uint64(w.filled), w.failures
+}
+
+func (w *countWindow) reset() {
+	for i := range w.outcomes {
+		w.outcomes[i] = false
+	}
+	w.next = 0
+	w.filled = 0
+	w.failures = 0
+}
+
+const timeWindowBuckets = 10
+
+type bucket struct {
+	index    int64
+	total    uint64
+	failures uint64
+}
+
+// timeWindow открывает breaker, если доля неудач за последние duration достигла порога.
+// Окно разбито на timeWindowBuckets корзин, устаревшие корзины переиспользуются.
+type timeWindow struct {
+	buckets         []bucket
+	bucketWidth     time.Duration
+	threshold       float64
+	minimumRequests uint64
+}
+
+func newTimeWindow(duration time.Duration, threshold float64, minimumRequests uint64) *timeWindow {
+	bucketWidth := duration / timeWindowBuckets
+	if bucketWidth <= 0 {
+		bucketWidth = 1
+	}
+	return &timeWindow{
+		buckets:         make([]bucket, timeWindowBuckets),
+		bucketWidth:     bucketWidth,
+		threshold:       threshold,
+		minimumRequests: minimumRequests,
+	}
+}
+
+func (w *timeWindow) onSuccess(now time.Time) {
+	w.current(now).total++
+}
+
+func (w *timeWindow) onFailure(now time.Time) {
+	b := w.current(now)
+	b.total++
+	b.failures++
+}
+
+func (w *timeWindow) current(now time.Time) *bucket {
+	index := now.UnixNano() / int64(w.bucketWidth)
+	b := &w.buckets[index%int64(len(w.buckets))]
+	if b.index != index {
+		*b = bucket{index: index}
+	}
+	return b
+}
+
+func (w *timeWindow) shouldTrip(now time.Time) bool {
+	total, failures := w.counts(now)
+	return tripByRate(total, failures, w.threshold, w.minimumRequests)
+}
+
+func (w *timeWindow) counts(now time.Time) (uint64, uint64) {
+	index := now.UnixNano() / int64(w.bucketWidth)
+	var total, failures uint64
+	for _, b := range w.buckets {
+		if b.index > index-int64(len(w.buckets)) && b.index <= index {
+			total += b.total
+			failures += b.failures
+		}
+	}
+	return total, failures
+}
+
+func (w *timeWindow) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = bucket{}
+	}
+}
+
+func tripByRate(total, failures uint64, threshold float64, minimumRequests uint64) bool {
+	if total == 0 || total < minimumRequests {
+		return false
+	}
+	return float64(failures)/float64(total) >= threshold
+}